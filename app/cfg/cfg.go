@@ -0,0 +1,27 @@
+package cfg
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/codecrafters-io/interpreter-starter-go/app/run"
+)
+
+// Graph はファイルをパースし、関数ごとの制御フローグラフを DOT 形式で出力する
+func Graph() {
+	filename := os.Args[2]
+	fileContents, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+		os.Exit(1)
+	}
+
+	program, ok := run.ParseProgram(fileContents)
+	if !ok {
+		os.Exit(65)
+	}
+	if err := program.WriteCFG(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing dot: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"io"
 	"os"
 	"strconv"
+
+	"github.com/codecrafters-io/interpreter-starter-go/app/run"
 )
 
 func Evaluate() {
@@ -26,9 +28,9 @@ func (u *Unary) getValue() EvaluateNode {
 			fmt.Fprintf(os.Stderr, "Operand must be a number.")
 			os.Exit(70)
 		}
-		num, _ := strconv.Atoi(u.right.getValue().value)
+		num, _ := strconv.ParseFloat(u.right.getValue().value, 64)
 		return EvaluateNode{
-			value:     strconv.FormatFloat(float64(num*-1), 'f', -1, 64),
+			value:     run.FormatNumber(-num),
 			valueType: NUMBER,
 		}
 	} else if u.operator.tokenType == BANG {
@@ -109,7 +111,7 @@ func (b *Binary) getValue() EvaluateNode {
 			left, _ := strconv.ParseFloat(b.left.getValue().value, 10)
 			right, _ := strconv.ParseFloat(b.right.getValue().value, 10)
 			return EvaluateNode{
-				value:     strconv.FormatFloat(left+right, 'f', -1, 64),
+				value:     run.FormatNumber(left + right),
 				valueType: NUMBER,
 			}
 		}
@@ -123,7 +125,7 @@ func (b *Binary) getValue() EvaluateNode {
 			os.Exit(70)
 		}
 		return EvaluateNode{
-			value:     strconv.FormatFloat(left/right, 'f', -1, 64),
+			value:     run.FormatNumber(left / right),
 			valueType: NUMBER,
 		}
 	} else if b.operator.tokenType == STAR {
@@ -132,7 +134,7 @@ func (b *Binary) getValue() EvaluateNode {
 			os.Exit(70)
 		}
 		return EvaluateNode{
-			value:     strconv.FormatFloat(left*right, 'f', -1, 64),
+			value:     run.FormatNumber(left * right),
 			valueType: NUMBER,
 		}
 	} else if b.operator.tokenType == MINUS {
@@ -141,7 +143,7 @@ func (b *Binary) getValue() EvaluateNode {
 			os.Exit(70)
 		}
 		return EvaluateNode{
-			value:     strconv.FormatFloat(left-right, 'f', -1, 64),
+			value:     run.FormatNumber(left - right),
 			valueType: NUMBER,
 		}
 	} else if b.operator.tokenType == GREATER {
@@ -5,6 +5,8 @@ import (
 	"os"
 	"strconv"
 	"unicode"
+
+	"github.com/codecrafters-io/interpreter-starter-go/app/run"
 )
 
 // tokenize は、ファイルの内容をトークンに変換します。
@@ -61,11 +63,10 @@ func tokenize(fileContents []byte) []Token {
 					i++
 					number_token += string(fileContents[i])
 				}
-				tmp_num, _ := strconv.ParseFloat(number_token, 64)
-				number_formatted = strconv.FormatFloat(tmp_num, 'g', -1, 64)
-			} else {
-				number_formatted = number_token
 			}
+			// run と同じ表記にするため、整数も含めて正規化する
+			tmp_num, _ := strconv.ParseFloat(number_token, 64)
+			number_formatted = run.FormatNumber(tmp_num)
 			tokens = append(tokens, Token{
 				tokenType: "NUMBER",
 				value:     number_formatted,
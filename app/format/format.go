@@ -0,0 +1,44 @@
+package format
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/codecrafters-io/interpreter-starter-go/app/run"
+)
+
+// Format はファイルを標準の書式に整形して出力する。
+// --check の場合は出力せず、整形済みでなければ終了コード 1 を返す
+func Format() {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	check := flags.Bool("check", false, "do not print the result; exit with 1 if the file is not formatted")
+	flags.Parse(os.Args[2:])
+	if flags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: ./your_program.sh fmt [--check] <filename>")
+		os.Exit(1)
+	}
+
+	filename := flags.Arg(0)
+	fileContents, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+		os.Exit(1)
+	}
+
+	program, ok := run.ParseProgram(fileContents)
+	if !ok {
+		os.Exit(65)
+	}
+	formatted := program.Format()
+
+	if *check {
+		if !bytes.Equal(fileContents, []byte(formatted)) {
+			fmt.Fprintf(os.Stderr, "%s: not formatted\n", filename)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Print(formatted)
+}
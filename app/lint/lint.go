@@ -0,0 +1,31 @@
+package lint
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/codecrafters-io/interpreter-starter-go/app/run"
+)
+
+// Lint はファイルをパースしてよくある間違いを報告する。
+// 問題が見つかった場合は終了コード 1 を返す
+func Lint() {
+	filename := os.Args[2]
+	fileContents, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+		os.Exit(1)
+	}
+
+	program, ok := run.ParseProgram(fileContents)
+	if !ok {
+		os.Exit(65)
+	}
+	findings := program.Lint()
+	for _, finding := range findings {
+		fmt.Printf("%s:%d: %s: %s\n", filename, finding.Line, finding.Rule, finding.Message)
+	}
+	if len(findings) > 0 {
+		os.Exit(1)
+	}
+}
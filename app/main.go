@@ -4,9 +4,13 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/codecrafters-io/interpreter-starter-go/app/cfg"
 	"github.com/codecrafters-io/interpreter-starter-go/app/evaluate"
+	"github.com/codecrafters-io/interpreter-starter-go/app/format"
+	"github.com/codecrafters-io/interpreter-starter-go/app/lint"
 	"github.com/codecrafters-io/interpreter-starter-go/app/parse"
 	"github.com/codecrafters-io/interpreter-starter-go/app/run"
+	"github.com/codecrafters-io/interpreter-starter-go/app/test"
 	"github.com/codecrafters-io/interpreter-starter-go/app/token"
 )
 
@@ -21,7 +25,8 @@ func main() {
 
 	command := os.Args[1]
 
-	if command != "parse" && command != "tokenize" && command != "evaluate" && command != "run" {
+	if command != "parse" && command != "tokenize" && command != "evaluate" && command != "run" &&
+		command != "cfg" && command != "fmt" && command != "lint" && command != "test" {
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
 		os.Exit(1)
 	}
@@ -41,4 +46,20 @@ func main() {
 	if command == "run" {
 		run.Run()
 	}
+
+	if command == "cfg" {
+		cfg.Graph()
+	}
+
+	if command == "fmt" {
+		format.Format()
+	}
+
+	if command == "lint" {
+		lint.Lint()
+	}
+
+	if command == "test" {
+		test.Test()
+	}
 }
@@ -2,12 +2,24 @@ package run
 
 type Token struct {
 	tokenType string
-	value     string
+	// リテラルの値。文字列ならエスケープを解釈した後の値
+	value string
+	// ソースコード上の表記
+	lexeme string
+	line   int
+	// ソースコード上の先頭のバイト位置
+	offset int
 }
 
 type Parser struct {
 	tokens []Token
 	index  int
+	// パース中に見つかった構文エラー
+	errors []error
+	// ノードと文ごとのソース上の範囲。nil なら記録しない
+	spans map[any]span
+	// 今パースしている式と文の入れ子の深さ
+	depth int
 }
 
 type Node interface {
@@ -18,7 +30,8 @@ type Node interface {
 type EvaluateNode struct {
 	value     string
 	valueType string
-	function  *Function // 関数の場合
+	function  *Function      // 関数の場合
+	list      []EvaluateNode // リストの場合
 }
 
 type AssignmentNode struct {
@@ -26,6 +39,21 @@ type AssignmentNode struct {
 	varName   string
 	value     Node
 	valueType string
+	line      int
+	binding   binding
+}
+
+// UpdateNode は x += 1 や x++ のように、変数の今の値から新しい値を計算して代入する式
+type UpdateNode struct {
+	Node
+	varName  string
+	operator Token
+	// 右辺。++ と -- の場合は nil
+	value Node
+	// ++x なら true、x++ なら false
+	prefix  bool
+	line    int
+	binding binding
 }
 
 type StringNode struct {
@@ -73,10 +101,30 @@ type Binary struct {
 	tokenType string
 }
 
+// Interpolation は "x = ${x}" のような文字列補間。
+// 文字列の部分と式を順番に持ち、評価すると全てを文字列にして連結する
+type Interpolation struct {
+	Node
+	parts     []Node
+	tokenType string
+	line      int
+}
+
+// Ternary は cond ? a : b の条件式
+type Ternary struct {
+	Node
+	condition  Node
+	thenBranch Node
+	elseBranch Node
+	tokenType  string
+}
+
 type IdentifierNode struct {
 	Node
 	value     string
 	tokenType string
+	line      int
+	binding   binding
 }
 
 type FuncNode struct {
@@ -84,6 +132,8 @@ type FuncNode struct {
 	callee    Node
 	arguments []Node
 	tokenType string
+	// 呼び出しの `)` がある行
+	line int
 }
 
 func (s *StringNode) getType() string {
@@ -114,6 +164,14 @@ func (b *Binary) getType() string {
 	return b.tokenType
 }
 
+func (i *Interpolation) getType() string {
+	return i.tokenType
+}
+
+func (t *Ternary) getType() string {
+	return t.tokenType
+}
+
 func (i *IdentifierNode) getType() string {
 	return i.tokenType
 }
@@ -124,4 +182,8 @@ func (f *FuncNode) getType() string {
 
 func (a *AssignmentNode) getType() string {
 	return a.valueType
-}
\ No newline at end of file
+}
+
+func (u *UpdateNode) getType() string {
+	return u.operator.tokenType
+}
@@ -0,0 +1,38 @@
+package run
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+)
+
+// benchmarkScript は bench/ のスクリプトを run コマンドと同じく最適化してから実行する時間を測る。
+// パースは計測に含めない
+func benchmarkScript(b *testing.B, filename string) {
+	source, err := os.ReadFile("../../bench/" + filename)
+	if err != nil {
+		b.Fatal(err)
+	}
+	program, ok := ParseProgram(source)
+	if !ok {
+		b.Fatalf("%s: syntax error", filename)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		interpreter := NewInterpreter(DefaultPolicy())
+		interpreter.SetOutput(io.Discard)
+		statements := interpreter.optimize(program.statements)
+		if err := interpreter.Interpret(context.Background(), statements); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFib(b *testing.B) {
+	benchmarkScript(b, "fib.lox")
+}
+
+func BenchmarkLoop(b *testing.B) {
+	benchmarkScript(b, "loop.lox")
+}
@@ -0,0 +1,263 @@
+package run
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// cfgBlock は制御フローグラフの基本ブロック。途中で分岐しない文の並び
+type cfgBlock struct {
+	id    int
+	lines []string
+	edges []cfgEdge
+}
+
+type cfgEdge struct {
+	to    *cfgBlock
+	label string
+}
+
+// cfgGraph は一つの関数 (またはトップレベルのスクリプト) の制御フローグラフ
+type cfgGraph struct {
+	name   string
+	blocks []*cfgBlock
+	entry  *cfgBlock
+	exit   *cfgBlock
+}
+
+// cfgBuilder は文を辿って制御フローグラフを作る。
+// 入れ子の関数は別のグラフとして graphs に追加する
+type cfgBuilder struct {
+	program *Program
+	graphs  []*cfgGraph
+	graph   *cfgGraph
+}
+
+// WriteCFG は関数ごとの制御フローグラフを Graphviz の DOT 形式で w に書き出す。
+// トップレベルの文は script という名前のグラフになる
+func (p *Program) WriteCFG(w io.Writer) error {
+	builder := &cfgBuilder{program: p}
+	builder.buildGraph("script", p.statements)
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "digraph cfg {")
+	fmt.Fprintln(out, "  node [shape=box, fontname=\"Helvetica\"];")
+	for i, graph := range builder.graphs {
+		fmt.Fprintf(out, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(out, "    label=%s;\n", dotString(graph.name))
+		for _, block := range graph.blocks {
+			id := fmt.Sprintf("g%d_b%d", i, block.id)
+			switch {
+			case block == graph.entry:
+				fmt.Fprintf(out, "    %s [label=\"entry\", shape=oval];\n", id)
+			case block == graph.exit:
+				fmt.Fprintf(out, "    %s [label=\"exit\", shape=oval];\n", id)
+			default:
+				// 各行を \l で終えて左揃えにする
+				label := ""
+				for _, line := range block.lines {
+					escaped := dotString(line)
+					label += escaped[1:len(escaped)-1] + `\l`
+				}
+				fmt.Fprintf(out, "    %s [label=\"%s\"];\n", id, label)
+			}
+			for _, edge := range block.edges {
+				to := fmt.Sprintf("g%d_b%d", i, edge.to.id)
+				if edge.label != "" {
+					fmt.Fprintf(out, "    %s -> %s [label=%s];\n", id, to, dotString(edge.label))
+				} else {
+					fmt.Fprintf(out, "    %s -> %s;\n", id, to)
+				}
+			}
+		}
+		fmt.Fprintln(out, "  }")
+	}
+	fmt.Fprintln(out, "}")
+	return out.Flush()
+}
+
+// buildGraph は statements を本体とするグラフを作る
+func (b *cfgBuilder) buildGraph(name string, statements []Statement) {
+	outer := b.graph
+	graph := &cfgGraph{name: name}
+	b.graphs = append(b.graphs, graph)
+	b.graph = graph
+
+	graph.entry = b.newBlock()
+	graph.exit = b.newBlock()
+	first := b.newBlock()
+	b.connect(graph.entry, first, "")
+	if last := b.buildStatements(statements, first); last != nil {
+		b.connect(last, graph.exit, "")
+	}
+	b.removeEmpty()
+
+	b.graph = outer
+}
+
+func (b *cfgBuilder) newBlock() *cfgBlock {
+	block := &cfgBlock{id: len(b.graph.blocks)}
+	b.graph.blocks = append(b.graph.blocks, block)
+	return block
+}
+
+func (b *cfgBuilder) connect(from *cfgBlock, to *cfgBlock, label string) {
+	from.edges = append(from.edges, cfgEdge{to: to, label: label})
+}
+
+// buildStatements は current の続きに statements を追加し、その後に制御が進むブロックを返す。
+// return で終わって後ろに進まない場合は nil を返す
+func (b *cfgBuilder) buildStatements(statements []Statement, current *cfgBlock) *cfgBlock {
+	for _, statement := range statements {
+		if current == nil {
+			// return の後ろの文は、どこからも到達しないブロックに置く
+			current = b.newBlock()
+		}
+		current = b.buildStatement(statement, current)
+	}
+	return current
+}
+
+func (b *cfgBuilder) buildStatement(statement Statement, current *cfgBlock) *cfgBlock {
+	switch s := statement.(type) {
+	case *BlockStatement:
+		return b.buildStatements(s.statements, current)
+	case *IfStatement:
+		return b.buildIf(s, current)
+	case *WhileStatement:
+		condition := b.newBlock()
+		b.connect(current, condition, "")
+		condition.lines = append(condition.lines, b.line(s, "while ("+b.program.text(s.expr)+")"))
+		body := b.newBlock()
+		b.connect(condition, body, "true")
+		if end := b.buildStatements(s.statements, body); end != nil {
+			b.connect(end, condition, "")
+		}
+		after := b.newBlock()
+		b.connect(condition, after, "false")
+		return after
+	case *ForStatement:
+		if b.program.written(s.firstStatement) {
+			current = b.buildStatement(s.firstStatement, current)
+		}
+		condition := b.newBlock()
+		b.connect(current, condition, "")
+		conditionText := ""
+		if b.program.written(s.expression) {
+			conditionText = b.program.text(s.expression)
+		}
+		condition.lines = append(condition.lines, b.line(s, "for (; "+conditionText+"; )"))
+		body := b.newBlock()
+		b.connect(condition, body, "true")
+		end := b.buildStatements(s.statements, body)
+		if end != nil {
+			if b.program.written(s.endStatement) {
+				increment := b.newBlock()
+				b.connect(end, increment, "")
+				end = b.buildStatement(s.endStatement, increment)
+			}
+			b.connect(end, condition, "")
+		}
+		after := b.newBlock()
+		// 条件が省略された for は false の辺を持たない
+		if b.program.written(s.expression) {
+			b.connect(condition, after, "false")
+		}
+		return after
+	case *ReturnStatement:
+		current.lines = append(current.lines, b.line(s, b.program.text(s)))
+		b.connect(current, b.graph.exit, "return")
+		return nil
+	case *FunStatement:
+		current.lines = append(current.lines, b.line(s, "fun "+s.name+"("+strings.Join(s.parameters, ", ")+")"))
+		b.buildGraph(s.name+"("+strings.Join(s.parameters, ", ")+")", s.statements)
+		return current
+	}
+	current.lines = append(current.lines, b.line(statement, b.program.text(statement)))
+	return current
+}
+
+// buildIf は if と else if の条件を順に判定するブロックを作り、全ての分岐が合流するブロックを返す
+func (b *cfgBuilder) buildIf(s *IfStatement, current *cfgBlock) *cfgBlock {
+	after := b.newBlock()
+	branch := func(condition *cfgBlock, statement *IfStatement) {
+		condition.lines = append(condition.lines, b.line(statement, "if ("+b.program.text(statement.expr)+")"))
+		then := b.newBlock()
+		b.connect(condition, then, "true")
+		if end := b.buildStatements(statement.statements, then); end != nil {
+			b.connect(end, after, "")
+		}
+	}
+
+	condition := b.newBlock()
+	b.connect(current, condition, "")
+	branch(condition, s)
+	for _, elseIf := range s.elseIfStatements {
+		next := b.newBlock()
+		b.connect(condition, next, "false")
+		branch(next, elseIf)
+		condition = next
+	}
+	if len(s.elseStatements) > 0 {
+		elseBlock := b.newBlock()
+		b.connect(condition, elseBlock, "false")
+		if end := b.buildStatements(s.elseStatements, elseBlock); end != nil {
+			b.connect(end, after, "")
+		}
+	} else {
+		b.connect(condition, after, "false")
+	}
+	return after
+}
+
+// removeEmpty は文を持たないブロックを取り除き、そこへの辺を行き先に付け替える。
+// entry と exit は残す
+func (b *cfgBuilder) removeEmpty() {
+	graph := b.graph
+	empty := func(block *cfgBlock) bool {
+		return block != graph.entry && block != graph.exit && len(block.lines) == 0 && len(block.edges) == 1 &&
+			block.edges[0].label == "" && block.edges[0].to != block
+	}
+	// 空のブロックが続く場合もあるので、行き先を辿る
+	resolve := func(block *cfgBlock) *cfgBlock {
+		for seen := 0; empty(block) && seen < len(graph.blocks); seen++ {
+			block = block.edges[0].to
+		}
+		return block
+	}
+	for _, block := range graph.blocks {
+		for i := range block.edges {
+			block.edges[i].to = resolve(block.edges[i].to)
+		}
+	}
+
+	blocks := make([]*cfgBlock, 0, len(graph.blocks))
+	for _, block := range graph.blocks {
+		// 辺の無い空のブロック (到達しない合流先など) も取り除く
+		if empty(block) || (block != graph.entry && block != graph.exit && len(block.lines) == 0 && len(block.edges) == 0) {
+			continue
+		}
+		blocks = append(blocks, block)
+	}
+	graph.blocks = blocks
+}
+
+// line は文の行番号を付けたラベルを作る
+func (b *cfgBuilder) line(statement Statement, text string) string {
+	return fmt.Sprintf("%d: %s", statement.getLine(), text)
+}
+
+// text は node のソースコードを一行にまとめて返す。長い場合は省略する
+func (p *Program) text(node any) string {
+	s, ok := p.spans[node]
+	if !ok {
+		return ""
+	}
+	text := strings.Join(strings.Fields(string(p.source[s.start:s.end])), " ")
+	if runes := []rune(text); len(runes) > 60 {
+		text = string(runes[:57]) + "..."
+	}
+	return text
+}
@@ -0,0 +1,176 @@
+package run
+
+import (
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Coverage は実行された文と分岐の回数をファイルごとに記録する。
+// 複数のスクリプトを実行して一つの LCOV にまとめられる
+type Coverage struct {
+	files []*coverageFile
+}
+
+// coverageFile は一つのスクリプトのカバレッジ
+type coverageFile struct {
+	name string
+	// 文ごとの実行回数。実行されなかった文も 0 で入っている
+	hits       map[Statement]int
+	statements []Statement
+	// if と while / for の分岐。出現順に並ぶ
+	branches []*coverageBranch
+	byNode   map[Statement]*coverageBranch
+}
+
+// coverageBranch は if の各分岐、またはループの本体が実行された回数。
+// if は then, else if..., else (書かれていなくても) の順に並ぶ。
+// ループは本体を実行した周回数と、一度も本体を実行せずに終わった回数の二つ
+type coverageBranch struct {
+	node   Statement
+	counts []int
+}
+
+func NewCoverage() *Coverage {
+	return &Coverage{}
+}
+
+// EnableCoverage は statements を実行したときの回数を c に filename として記録するようにする。
+// 最適化で取り除かれた文や分岐も数えられるように、最適化する前の文を渡す
+func (in *Interpreter) EnableCoverage(c *Coverage, filename string, statements []Statement) {
+	file := &coverageFile{
+		name:   filename,
+		hits:   make(map[Statement]int),
+		byNode: make(map[Statement]*coverageBranch),
+	}
+	file.register(statements)
+	c.files = append(c.files, file)
+	in.coverage = file
+}
+
+// register は文とその中の文、分岐を実行回数 0 として登録する
+func (c *coverageFile) register(statements []Statement) {
+	for _, statement := range statements {
+		c.hits[statement] = 0
+		c.statements = append(c.statements, statement)
+		switch s := statement.(type) {
+		case *BlockStatement:
+			c.register(s.statements)
+		case *FunStatement:
+			c.register(s.statements)
+		case *IfStatement:
+			c.addBranch(s, len(s.elseIfStatements)+2)
+			c.register(s.statements)
+			for _, elseIf := range s.elseIfStatements {
+				c.register(elseIf.statements)
+			}
+			c.register(s.elseStatements)
+		case *WhileStatement:
+			c.addBranch(s, 2)
+			c.register(s.statements)
+		case *ForStatement:
+			c.addBranch(s, 2)
+			c.register([]Statement{s.firstStatement})
+			c.register(s.statements)
+			c.register([]Statement{s.endStatement})
+		}
+	}
+}
+
+func (c *coverageFile) addBranch(node Statement, arms int) {
+	branch := &coverageBranch{node: node, counts: make([]int, arms)}
+	c.branches = append(c.branches, branch)
+	c.byNode[node] = branch
+}
+
+// coverBranch は node の arm 番目の分岐が実行されたことを記録する
+func (in *Interpreter) coverBranch(node Statement, arm int) {
+	if in.coverage != nil {
+		in.coverage.byNode[node].counts[arm]++
+	}
+}
+
+// lines は行ごとの実行回数を行番号順に返す。
+// 一行に複数の文がある場合は一番多く実行された文の回数にする
+func (c *coverageFile) lines() (lines []int, hits map[int]int) {
+	hits = make(map[int]int)
+	for _, statement := range c.statements {
+		line := statement.getLine()
+		count, ok := hits[line]
+		if !ok {
+			lines = append(lines, line)
+		}
+		hits[line] = max(count, c.hits[statement])
+	}
+	slices.Sort(lines)
+	return lines, hits
+}
+
+// WriteLCOV はカバレッジを LCOV の形式で w に書き出す
+func (c *Coverage) WriteLCOV(w io.Writer) error {
+	for _, file := range c.files {
+		fmt.Fprintln(w, "TN:")
+		fmt.Fprintf(w, "SF:%s\n", file.name)
+
+		taken, total := 0, 0
+		for block, branch := range file.branches {
+			for arm, count := range branch.counts {
+				total++
+				// 分岐そのものが実行されていなければ "-" にする
+				if file.hits[branch.node] == 0 {
+					fmt.Fprintf(w, "BRDA:%d,%d,%d,-\n", branch.node.getLine(), block, arm)
+					continue
+				}
+				if count > 0 {
+					taken++
+				}
+				fmt.Fprintf(w, "BRDA:%d,%d,%d,%d\n", branch.node.getLine(), block, arm, count)
+			}
+		}
+		fmt.Fprintf(w, "BRF:%d\nBRH:%d\n", total, taken)
+
+		lines, hits := file.lines()
+		hit := 0
+		for _, line := range lines {
+			if hits[line] > 0 {
+				hit++
+			}
+			fmt.Fprintf(w, "DA:%d,%d\n", line, hits[line])
+		}
+		fmt.Fprintf(w, "LF:%d\nLH:%d\n", len(lines), hit)
+		if _, err := fmt.Fprintln(w, "end_of_record"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteSummary はファイルごとの行と分岐のカバレッジを一行ずつ w に書き出す
+func (c *Coverage) WriteSummary(w io.Writer) {
+	for _, file := range c.files {
+		lines, hits := file.lines()
+		hit := 0
+		for _, line := range lines {
+			if hits[line] > 0 {
+				hit++
+			}
+		}
+		taken, total := 0, 0
+		for _, branch := range file.branches {
+			for _, count := range branch.counts {
+				total++
+				if count > 0 {
+					taken++
+				}
+			}
+		}
+		fmt.Fprintf(w, "%s: lines %s, branches %s\n", file.name, percent(hit, len(lines)), percent(taken, total))
+	}
+}
+
+func percent(covered, total int) string {
+	if total == 0 {
+		return "0/0"
+	}
+	return fmt.Sprintf("%d/%d (%.1f%%)", covered, total, 100*float64(covered)/float64(total))
+}
@@ -0,0 +1,68 @@
+package run
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteDot は構文木を Graphviz の DOT 形式で w に書き出す。
+// 辺のラベルは親から見た子の役割 (condition, body など)
+func (p *Program) WriteDot(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "digraph ast {")
+	fmt.Fprintln(out, "  node [shape=box, fontname=\"Helvetica\"];")
+	count := 0
+	var walk func(node *SyntaxNode) string
+	walk = func(node *SyntaxNode) string {
+		id := fmt.Sprintf("n%d", count)
+		count++
+		fmt.Fprintf(out, "  %s [label=%s];\n", id, dotString(syntaxLabel(node)))
+		for _, child := range node.Children {
+			childID := walk(child)
+			if child.Role != "" {
+				fmt.Fprintf(out, "  %s -> %s [label=%s];\n", id, childID, dotString(child.Role))
+			} else {
+				fmt.Fprintf(out, "  %s -> %s;\n", id, childID)
+			}
+		}
+		return id
+	}
+	walk(p.SyntaxTree())
+	fmt.Fprintln(out, "}")
+	return out.Flush()
+}
+
+// syntaxLabel はノードの種類と、名前や演算子、値を一つのラベルにする
+func syntaxLabel(node *SyntaxNode) string {
+	label := node.Kind
+	if node.Name != "" {
+		label += " " + node.Name
+	}
+	if node.Params != nil {
+		label += "(" + strings.Join(node.Params, ", ") + ")"
+	}
+	if node.Operator != "" {
+		label += " " + node.Operator
+	}
+	if node.Value != nil {
+		if value, ok := node.Value.(string); ok && node.Kind == "String" {
+			label += fmt.Sprintf(" %q", value)
+		} else {
+			label += fmt.Sprintf(" %v", node.Value)
+		}
+	}
+	if node.Span != nil {
+		label += fmt.Sprintf("\n[line %d]", node.Span.Start.Line)
+	}
+	return label
+}
+
+// dotString は DOT の文字列リテラルにする
+func dotString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
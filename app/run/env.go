@@ -1,8 +1,14 @@
 package run
 
+// Env は変数の環境。
+// グローバル環境だけが名前の map を持ち、ブロックや関数呼び出しの環境は
+// resolve で決めた番号 (slot) で引く slice に変数を持つ
 type Env struct {
 	variables map[string]EvaluateNode
+	slots     []EvaluateNode
 	parentEnv *Env
+	// 実行中のインタプリタ。子の環境にも引き継がれる
+	interpreter *Interpreter
 }
 
 type Function struct {
@@ -10,25 +16,33 @@ type Function struct {
 	parameters []string
 	statements []Statement
 	closure    *Env
+	// 宣言された行。組み込み関数は 0
+	line int
+	// 組み込み関数の場合のみ設定される
+	native nativeFunc
+	arity  int
 }
 
+// NewEnv creates the global environment with the native functions defined.
 func NewEnv() *Env {
-	return &Env{
+	env := &Env{
 		variables: map[string]EvaluateNode{},
 		parentEnv: nil,
 	}
+	defineNatives(env)
+	return env
 }
 
-
 // NewChildEnv creates a new child environment that inherits from the current environment.
 func (e *Env) NewChildEnv() *Env {
 	return &Env{
-		variables: map[string]EvaluateNode{},
-		parentEnv: e,
+		parentEnv:   e,
+		interpreter: e.interpreter,
 	}
 }
 
-// Get looks up a variable in this environment or parent environments
+// Get looks up a variable by name in this environment or parent environments.
+// Only the global environment has names, so this finds globals only.
 func (e *Env) Get(name string) (EvaluateNode, bool) {
 	if val, ok := e.variables[name]; ok {
 		return val, true
@@ -56,3 +70,56 @@ func (e *Env) Define(name string, value EvaluateNode) {
 	e.variables[name] = value
 }
 
+// binding は resolve で決まる変数の場所。
+// local が false ならグローバル変数で、名前で探す
+type binding struct {
+	local bool
+	// 何個外側の環境にあるか
+	depth int
+	slot  int
+}
+
+// ancestor は depth 個外側の環境を返す
+func (e *Env) ancestor(depth int) *Env {
+	for ; depth > 0; depth-- {
+		e = e.parentEnv
+	}
+	return e
+}
+
+// lookup は b の場所にある変数を返す。まだ定義されていなければ false を返す
+func (e *Env) lookup(b binding, name string) (EvaluateNode, bool) {
+	if !b.local {
+		return e.interpreter.globals.Get(name)
+	}
+	env := e.ancestor(b.depth)
+	if b.slot >= len(env.slots) || env.slots[b.slot].valueType == "" {
+		return EvaluateNode{}, false
+	}
+	return env.slots[b.slot], true
+}
+
+// assign は b の場所にある定義済みの変数を更新する。未定義なら false を返す
+func (e *Env) assign(b binding, name string, value EvaluateNode) bool {
+	if !b.local {
+		return e.interpreter.globals.Set(name, value)
+	}
+	env := e.ancestor(b.depth)
+	if b.slot >= len(env.slots) || env.slots[b.slot].valueType == "" {
+		return false
+	}
+	env.slots[b.slot] = value
+	return true
+}
+
+// declare は b の場所に変数を定義する。ローカル変数の宣言は常に今の環境にある
+func (e *Env) declare(b binding, name string, value EvaluateNode) {
+	if !b.local {
+		e.Define(name, value)
+		return
+	}
+	for b.slot >= len(e.slots) {
+		e.slots = append(e.slots, EvaluateNode{})
+	}
+	e.slots[b.slot] = value
+}
@@ -1,21 +1,29 @@
 package run
 
 import (
-	"fmt"
-	"os"
+	"math"
 	"strconv"
-	"time"
+	"strings"
 )
 
 func (u *Unary) getValue(env *Env) EvaluateNode {
 	if u.operator.tokenType == MINUS {
-		if u.right.getValue(env).valueType != NUMBER {
-			fmt.Fprintf(os.Stderr, "Operand must be a number.")
-			os.Exit(70)
+		right := u.right.getValue(env)
+		if right.valueType != NUMBER {
+			runtimeError(env, u.operator.line, "Operand must be a number.")
 		}
-		num, _ := strconv.ParseFloat(u.right.getValue(env).value, 64)
+		num, _ := strconv.ParseFloat(right.value, 64)
 		return EvaluateNode{
-			value:     strconv.FormatFloat(-num, 'f', -1, 64),
+			value:     FormatNumber(-num),
+			valueType: NUMBER,
+		}
+	} else if u.operator.tokenType == TILDE {
+		num, ok := toInteger(u.right.getValue(env))
+		if !ok {
+			runtimeError(env, u.operator.line, "Operand must be an integer.")
+		}
+		return EvaluateNode{
+			value:     strconv.FormatInt(^num, 10),
 			valueType: NUMBER,
 		}
 	} else if u.operator.tokenType == BANG {
@@ -38,10 +46,7 @@ func (u *Unary) getValue(env *Env) EvaluateNode {
 
 func (g *Group) getValue(env *Env) EvaluateNode {
 	if len(g.nodes) == 1 {
-		return EvaluateNode{
-			value:     g.nodes[0].getValue(env).value,
-			valueType: g.nodes[0].getValue(env).valueType,
-		}
+		return g.nodes[0].getValue(env)
 	} else {
 		values := ""
 		for i, n := range g.nodes {
@@ -60,32 +65,53 @@ func (g *Group) getValue(env *Env) EvaluateNode {
 func (a *AssignmentNode) getValue(env *Env) EvaluateNode {
 	// 値を評価
 	result := a.value.getValue(env)
-	
+
 	// 変数に値をセット
-	if !env.Set(a.varName, result) {
-		fmt.Fprintf(os.Stderr, "Undefined variable '%s'.\n", a.varName)
-		os.Exit(70)
+	if !env.assign(a.binding, a.varName, result) {
+		runtimeError(env, a.line, "Undefined variable '%s'.", a.varName)
+	}
+	if tracer := env.interpreter.tracer; tracer != nil {
+		tracer.assign(a.varName, result)
 	}
-	
+
 	return result
 }
 
-func (i *IdentifierNode) getValue(env *Env) EvaluateNode {
-	// 特殊な組み込み関数の場合
-	if i.value == "clock" {
-		return EvaluateNode{
-			value:     "clock",
-			valueType: STRING,
-		}
+func (u *UpdateNode) getValue(env *Env) EvaluateNode {
+	current, ok := env.lookup(u.binding, u.varName)
+	if !ok {
+		runtimeError(env, u.line, "Undefined variable '%s'.", u.varName)
+	}
+
+	operand := EvaluateNode{value: "1", valueType: NUMBER}
+	if u.value != nil {
+		operand = u.value.getValue(env)
+	} else if current.valueType != NUMBER {
+		runtimeError(env, u.operator.line, "Operand must be a number.")
 	}
 
+	operator := updateOperators[u.operator.tokenType]
+	operator.line = u.operator.line
+	result := evaluateBinary(env, operator, current, operand)
+	env.assign(u.binding, u.varName, result)
+	if tracer := env.interpreter.tracer; tracer != nil {
+		tracer.assign(u.varName, result)
+	}
+
+	// x++ は更新前の値を返す
+	if u.value == nil && !u.prefix {
+		return current
+	}
+	return result
+}
+
+func (i *IdentifierNode) getValue(env *Env) EvaluateNode {
 	// 変数を探す
-	if val, ok := env.Get(i.value); ok {
+	if val, ok := env.lookup(i.binding, i.value); ok {
 		return val
 	}
 
-	fmt.Fprintf(os.Stderr, "Undefined variable '%s'.\n", i.value)
-	os.Exit(70)
+	runtimeError(env, i.line, "Undefined variable '%s'.", i.value)
 	return EvaluateNode{}
 }
 
@@ -118,59 +144,154 @@ func (n *NilNode) getValue(env *Env) EvaluateNode {
 }
 
 func (b *Binary) getValue(env *Env) EvaluateNode {
-	if b.operator.tokenType == PLUS {
-		if b.left.getValue(env).valueType != b.right.getValue(env).valueType {
-			fmt.Fprintf(os.Stderr, "Operands must be same types.")
-			os.Exit(70)
+	if b.operator.tokenType == OR {
+		leftValue := b.left.getValue(env)
+		if isTrueString(leftValue.value) {
+			return leftValue
+		}
+
+		rightValue := b.right.getValue(env)
+		if isTrueString(rightValue.value) {
+			return rightValue
+		}
+
+		return EvaluateNode{
+			value:     "false",
+			valueType: BOOLEAN,
 		}
-		if b.left.getValue(env).valueType == STRING {
+	} else if b.operator.tokenType == AND {
+		// 左辺が偽なら右辺は評価しない
+		if isTrueString(b.left.getValue(env).value) {
+			rightValue := b.right.getValue(env)
+			if isTrueString(rightValue.value) {
+				return rightValue
+			}
+		}
+		return EvaluateNode{
+			value:     "false",
+			valueType: BOOLEAN,
+		}
+	}
+
+	return evaluateBinary(env, b.operator, b.left.getValue(env), b.right.getValue(env))
+}
+
+// evaluateBinary は評価済みの両辺に二項演算子を適用する。
+// and / or 以外の演算子はここで計算する
+func evaluateBinary(env *Env, operator Token, leftValue EvaluateNode, rightValue EvaluateNode) EvaluateNode {
+	if operator.tokenType == PLUS {
+		if leftValue.valueType != rightValue.valueType {
+			runtimeError(env, operator.line, "Operands must be same types.")
+		}
+		if leftValue.valueType == STRING {
+			value := leftValue.value + rightValue.value
+			env.interpreter.checkString(env, operator.line, value)
 			return EvaluateNode{
-				value:     b.left.getValue(env).value + b.right.getValue(env).value,
+				value:     value,
 				valueType: STRING,
 			}
-		} else if b.left.getValue(env).valueType == NUMBER {
-			left, _ := strconv.ParseFloat(b.left.getValue(env).value, 10)
-			right, _ := strconv.ParseFloat(b.right.getValue(env).value, 10)
+		} else if leftValue.valueType == NUMBER {
+			left, _ := strconv.ParseFloat(leftValue.value, 10)
+			right, _ := strconv.ParseFloat(rightValue.value, 10)
 			return EvaluateNode{
-				value:     strconv.FormatFloat(left+right, 'f', -1, 64),
+				value:     FormatNumber(left + right),
 				valueType: NUMBER,
 			}
 		}
 	}
 
-	left, _ := strconv.ParseFloat(b.left.getValue(env).value, 10)
-	right, _ := strconv.ParseFloat(b.right.getValue(env).value, 10)
-	if b.operator.tokenType == SLASH {
-		if b.left.getValue(env).valueType != NUMBER || b.right.getValue(env).valueType != NUMBER {
-			fmt.Fprintf(os.Stderr, "Operands must be numbers.")
-			os.Exit(70)
+	left, _ := strconv.ParseFloat(leftValue.value, 10)
+	right, _ := strconv.ParseFloat(rightValue.value, 10)
+	if operator.tokenType == SLASH {
+		if leftValue.valueType != NUMBER || rightValue.valueType != NUMBER {
+			runtimeError(env, operator.line, "Operands must be numbers.")
+		}
+		if right == 0 {
+			runtimeError(env, operator.line, "Division by zero.")
+		}
+		return EvaluateNode{
+			value:     FormatNumber(left / right),
+			valueType: NUMBER,
+		}
+	} else if operator.tokenType == PERCENT || operator.tokenType == TILDE_SLASH {
+		if leftValue.valueType != NUMBER || rightValue.valueType != NUMBER {
+			runtimeError(env, operator.line, "Operands must be numbers.")
+		}
+		if right == 0 {
+			runtimeError(env, operator.line, "Division by zero.")
+		}
+		// ~/ は負の無限大の方向に丸めた商 (floor)、% はそれに対応する余りで、右辺と同じ符号になる。
+		// どちらも同じ丸め方なので a == b * (a ~/ b) + a % b が成り立つ
+		result := math.Mod(left, right)
+		if result != 0 && (result < 0) != (right < 0) {
+			result += right
+		} else if result == 0 {
+			// -6 % 3 が -0 にならないようにする
+			result = 0
+		}
+		if operator.tokenType == TILDE_SLASH {
+			result = math.Floor(left / right)
+		}
+		return EvaluateNode{
+			value:     FormatNumber(result),
+			valueType: NUMBER,
+		}
+	} else if operator.tokenType == STAR_STAR {
+		if leftValue.valueType != NUMBER || rightValue.valueType != NUMBER {
+			runtimeError(env, operator.line, "Operands must be numbers.")
+		}
+		return EvaluateNode{
+			value:     FormatNumber(math.Pow(left, right)),
+			valueType: NUMBER,
+		}
+	} else if operator.tokenType == AMPERSAND || operator.tokenType == PIPE || operator.tokenType == CARET ||
+		operator.tokenType == LESS_LESS || operator.tokenType == GREATER_GREATER {
+		leftInt, leftOk := toInteger(leftValue)
+		rightInt, rightOk := toInteger(rightValue)
+		if !leftOk || !rightOk {
+			runtimeError(env, operator.line, "Operands must be integers.")
+		}
+		var result int64
+		switch operator.tokenType {
+		case AMPERSAND:
+			result = leftInt & rightInt
+		case PIPE:
+			result = leftInt | rightInt
+		case CARET:
+			result = leftInt ^ rightInt
+		case LESS_LESS, GREATER_GREATER:
+			if rightInt < 0 {
+				runtimeError(env, operator.line, "Shift count must be non-negative.")
+			}
+			if operator.tokenType == LESS_LESS {
+				result = leftInt << rightInt
+			} else {
+				result = leftInt >> rightInt
+			}
 		}
 		return EvaluateNode{
-			value:     strconv.FormatFloat(left/right, 'f', -1, 64),
+			value:     strconv.FormatInt(result, 10),
 			valueType: NUMBER,
 		}
-	} else if b.operator.tokenType == STAR {
-		if b.left.getValue(env).valueType != NUMBER || b.right.getValue(env).valueType != NUMBER {
-			fmt.Fprintf(os.Stderr, "Operands must be numbers.")
-			os.Exit(70)
+	} else if operator.tokenType == STAR {
+		if leftValue.valueType != NUMBER || rightValue.valueType != NUMBER {
+			runtimeError(env, operator.line, "Operands must be numbers.")
 		}
 		return EvaluateNode{
-			value:     strconv.FormatFloat(left*right, 'f', -1, 64),
+			value:     FormatNumber(left * right),
 			valueType: NUMBER,
 		}
-	} else if b.operator.tokenType == MINUS {
-		if b.left.getValue(env).valueType != NUMBER || b.right.getValue(env).valueType != NUMBER {
-			fmt.Fprintf(os.Stderr, "Operands must be numbers.")
-			os.Exit(70)
+	} else if operator.tokenType == MINUS {
+		if leftValue.valueType != NUMBER || rightValue.valueType != NUMBER {
+			runtimeError(env, operator.line, "Operands must be numbers.")
 		}
 		return EvaluateNode{
-			value:     strconv.FormatFloat(left-right, 'f', -1, 64),
+			value:     FormatNumber(left - right),
 			valueType: NUMBER,
 		}
-	} else if b.operator.tokenType == GREATER {
-		if b.left.getValue(env).valueType != NUMBER || b.right.getValue(env).valueType != NUMBER {
-			fmt.Fprintf(os.Stderr, "Operands must be same types.")
-			os.Exit(70)
+	} else if operator.tokenType == GREATER {
+		if leftValue.valueType != NUMBER || rightValue.valueType != NUMBER {
+			runtimeError(env, operator.line, "Operands must be same types.")
 		}
 		if left > right {
 			return EvaluateNode{
@@ -183,10 +304,9 @@ func (b *Binary) getValue(env *Env) EvaluateNode {
 				valueType: BOOLEAN,
 			}
 		}
-	} else if b.operator.tokenType == GREATER_EQUAL {
-		if b.left.getValue(env).valueType != NUMBER || b.right.getValue(env).valueType != NUMBER {
-			fmt.Fprintf(os.Stderr, "Operands must be same types.")
-			os.Exit(70)
+	} else if operator.tokenType == GREATER_EQUAL {
+		if leftValue.valueType != NUMBER || rightValue.valueType != NUMBER {
+			runtimeError(env, operator.line, "Operands must be same types.")
 		}
 		if left >= right {
 			return EvaluateNode{
@@ -199,10 +319,9 @@ func (b *Binary) getValue(env *Env) EvaluateNode {
 				valueType: BOOLEAN,
 			}
 		}
-	} else if b.operator.tokenType == LESS {
-		if b.left.getValue(env).valueType != NUMBER || b.right.getValue(env).valueType != NUMBER {
-			fmt.Fprintf(os.Stderr, "Operands must be same types.")
-			os.Exit(70)
+	} else if operator.tokenType == LESS {
+		if leftValue.valueType != NUMBER || rightValue.valueType != NUMBER {
+			runtimeError(env, operator.line, "Operands must be same types.")
 		}
 		if left < right {
 			return EvaluateNode{
@@ -215,10 +334,9 @@ func (b *Binary) getValue(env *Env) EvaluateNode {
 				valueType: BOOLEAN,
 			}
 		}
-	} else if b.operator.tokenType == LESS_EQUAL {
-		if b.left.getValue(env).valueType != NUMBER || b.right.getValue(env).valueType != NUMBER {
-			fmt.Fprintf(os.Stderr, "Operands must be same types.")
-			os.Exit(70)
+	} else if operator.tokenType == LESS_EQUAL {
+		if leftValue.valueType != NUMBER || rightValue.valueType != NUMBER {
+			runtimeError(env, operator.line, "Operands must be same types.")
 		}
 		if left <= right {
 			return EvaluateNode{
@@ -231,8 +349,8 @@ func (b *Binary) getValue(env *Env) EvaluateNode {
 				valueType: BOOLEAN,
 			}
 		}
-	} else if b.operator.tokenType == EQUAL_EQUAL {
-		if b.left.getValue(env).value == b.right.getValue(env).value && b.left.getValue(env).valueType == b.right.getValue(env).valueType {
+	} else if operator.tokenType == EQUAL_EQUAL {
+		if valuesEqual(leftValue, rightValue) {
 			return EvaluateNode{
 				value:     "true",
 				valueType: BOOLEAN,
@@ -243,8 +361,8 @@ func (b *Binary) getValue(env *Env) EvaluateNode {
 				valueType: BOOLEAN,
 			}
 		}
-	} else if b.operator.tokenType == BANG_EQUAL {
-		if b.left.getValue(env).value != b.right.getValue(env).value || b.left.getValue(env).valueType != b.right.getValue(env).valueType {
+	} else if operator.tokenType == BANG_EQUAL {
+		if !valuesEqual(leftValue, rightValue) {
 			return EvaluateNode{
 				value:     "true",
 				valueType: BOOLEAN,
@@ -257,43 +375,47 @@ func (b *Binary) getValue(env *Env) EvaluateNode {
 		}
 	}
 
-	if b.operator.tokenType == OR {
-		leftValue := b.left.getValue(env)
-		if isTrueString(leftValue.value) {
-			return leftValue
-		}
-
-		rightValue := b.right.getValue(env)
-		if isTrueString(rightValue.value) {
-			return rightValue
-		}
+	panic("Unknown operator: " + operator.tokenType)
+}
 
-		return EvaluateNode{
-			value:     "false",
-			valueType: BOOLEAN,
-		}
-	} else if b.operator.tokenType == AND {
-		if isTrueString(b.left.getValue(env).value) && isTrueString(b.right.getValue(env).value) {
-			return EvaluateNode{
-				value:     b.right.getValue(env).value,
-				valueType: b.right.getValue(env).valueType,
-			}
-		} else {
-			return EvaluateNode{
-				value:     "false",
-				valueType: BOOLEAN,
-			}
-		}
+func (i *Interpolation) getValue(env *Env) EvaluateNode {
+	var value strings.Builder
+	for _, part := range i.parts {
+		value.WriteString(stringify(part.getValue(env)))
+	}
+	env.interpreter.checkString(env, i.line, value.String())
+	return EvaluateNode{
+		value:     value.String(),
+		valueType: STRING,
 	}
+}
 
-	panic("Unknown operator: " + b.operator.tokenType)
+func (t *Ternary) getValue(env *Env) EvaluateNode {
+	// 選ばれた方の式だけを評価する
+	if isTrueString(t.condition.getValue(env).value) {
+		return t.thenBranch.getValue(env)
+	}
+	return t.elseBranch.getValue(env)
 }
 
 func (e *EvaluateNode) getValue(env *Env) EvaluateNode {
 	return EvaluateNode{
 		value:     e.value,
 		valueType: e.valueType,
+		list:      e.list,
+	}
+}
+
+// toInteger は値が整数の数値であれば int64 に変換する
+func toInteger(node EvaluateNode) (int64, bool) {
+	if node.valueType != NUMBER {
+		return 0, false
+	}
+	num, err := strconv.ParseFloat(node.value, 64)
+	if err != nil || num != math.Trunc(num) || math.Abs(num) >= 1<<63 {
+		return 0, false
 	}
+	return int64(num), true
 }
 
 func isTrueString(value string) bool {
@@ -316,14 +438,6 @@ func isTrueString(value string) bool {
 func (f *FuncNode) getValue(env *Env) EvaluateNode {
 	// calleeを評価
 	calleeValue := f.callee.getValue(env)
-	
-	// clock関数の特別処理
-	if calleeValue.value == "clock" {
-		return EvaluateNode{
-			value:     strconv.FormatInt(time.Now().Unix(), 10),
-			valueType: NUMBER,
-		}
-	}
 
 	// 関数を取得
 	var funcDef *Function
@@ -341,38 +455,75 @@ func (f *FuncNode) getValue(env *Env) EvaluateNode {
 
 	// 関数が見つからなかったらエラー
 	if funcDef == nil {
-		fmt.Fprintf(os.Stderr, "Undefined function '%s'.\n", calleeValue.value)
-		os.Exit(70)
+		runtimeError(env, f.line, "Undefined function '%s'.", calleeValue.value)
 	}
 
-	if len(f.arguments) != len(funcDef.parameters) {
-		fmt.Fprintf(os.Stderr, "Function '%s' expects %d arguments, but got %d.\n", funcDef.name, len(funcDef.parameters), len(f.arguments))
-		os.Exit(70)
+	if len(f.arguments) != funcDef.getArity() {
+		runtimeError(env, f.line, "Function '%s' expects %d arguments, but got %d.", funcDef.name, funcDef.getArity(), len(f.arguments))
+	}
+
+	// 組み込み関数は Go の関数をそのまま呼ぶ
+	if funcDef.native != nil {
+		args := make([]EvaluateNode, 0, len(f.arguments))
+		for _, arg := range f.arguments {
+			args = append(args, arg.getValue(env))
+		}
+		if profiler := env.interpreter.profiler; profiler != nil {
+			profiler.enterFunction(funcDef)
+			defer profiler.exitFunction()
+		}
+		tracer := env.interpreter.tracer
+		if tracer != nil {
+			tracer.enter(funcDef, args)
+		}
+		result := funcDef.native(env, f.line, args)
+		if tracer != nil {
+			tracer.exit(funcDef, result)
+		}
+		return result
 	}
 	// 関数のクロージャ環境から新しい環境を作成
 	newEnv := funcDef.closure.NewChildEnv()
 
-	// 引数を新しい環境にバインド
+	// 引数を新しい環境にバインドする。引数は resolve で先頭から順に番号を振られている
+	newEnv.slots = make([]EvaluateNode, len(f.arguments))
 	for index, arg := range f.arguments {
-		argument := arg.getValue(env)
-		newEnv.Define(funcDef.parameters[index], argument)
+		newEnv.slots[index] = arg.getValue(env)
+	}
+
+	// スタックトレース用に呼び出しを記録する
+	interpreter := env.interpreter
+	interpreter.pushFrame(env, funcDef.name, f.line)
+	defer interpreter.popFrame()
+	if interpreter.profiler != nil {
+		interpreter.profiler.enterFunction(funcDef)
+		defer interpreter.profiler.exitFunction()
+	}
+	if interpreter.tracer != nil {
+		interpreter.tracer.enter(funcDef, newEnv.slots)
 	}
 
+	result := EvaluateNode{
+		value:     "nil",
+		valueType: NIL,
+	}
 	for _, statement := range funcDef.statements {
 		// 実際にはエラーではないが、エラーとして扱う
 		// 実際には return で返ってくるものが入っている
-		err := statement.Execute(newEnv)
+		err := execute(statement, newEnv)
 		if err != nil {
-			return EvaluateNode{
+			result = EvaluateNode{
 				value:     err.value,
 				valueType: err.valueType,
 				function:  err.function,
+				list:      err.list,
 			}
+			break
 		}
 	}
 
-	return EvaluateNode{
-		value:     "nil",
-		valueType: NIL,
+	if interpreter.tracer != nil {
+		interpreter.tracer.exit(funcDef, result)
 	}
-}
\ No newline at end of file
+	return result
+}
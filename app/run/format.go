@@ -0,0 +1,294 @@
+package run
+
+import (
+	"sort"
+	"strings"
+)
+
+// formatIndent は一段分のインデント
+const formatIndent = "    "
+
+// formatter は構文木からソースコードを組み立て直す。
+// コメントは構文木に含まれないので、ソース上の位置を見て文の間に挟み込む
+type formatter struct {
+	program *Program
+	lines   []string
+	depth   int
+	// 直前に出力した行が `{` で終わるヘッダなら true
+	open bool
+	// 次に出力するコメントの添字
+	comment int
+	// 最後に出力した文かコメントの終わりの位置。-1 ならブロックの先頭
+	last int
+}
+
+// Format はプログラムを標準の書式で整形したソースコードを返す。
+// インデントは空白 4 つで、if / while / for の本体は必ず { } で囲む。
+// 空行は文の間に一行まで残す
+func (p *Program) Format() string {
+	f := &formatter{program: p, last: -1}
+	f.statements(p.statements, len(p.source))
+	if len(f.lines) == 0 {
+		return ""
+	}
+	return strings.Join(f.lines, "\n") + "\n"
+}
+
+// statements は文を一つずつ出力し、最後に end より前にあるコメントを出力する
+func (f *formatter) statements(statements []Statement, end int) {
+	for _, statement := range statements {
+		s := f.program.spans[statement]
+		f.comments(s.start)
+		f.separate(s.start)
+		f.innerComments(statement, s.end)
+		f.statement(statement)
+		f.last = s.end
+		f.trailingComment()
+	}
+	f.comments(end)
+}
+
+// comments は before より前にある未出力のコメントを、それぞれ一行として出力する
+func (f *formatter) comments(before int) {
+	for f.comment < len(f.program.comments) && f.program.comments[f.comment].offset < before {
+		comment := f.program.comments[f.comment]
+		f.separate(comment.offset)
+		f.line(comment.lexeme)
+		f.open = false
+		f.last = comment.offset + len(comment.lexeme)
+		f.comment++
+	}
+}
+
+// innerComments は一行で出力する文の途中 (複数行にわたる式の間など) にあるコメントを、
+// 文の前にそれぞれ一行として出力する。
+// 本体を持つ文の中のコメントは、本体を出力するときに本体の中に出力する
+func (f *formatter) innerComments(statement Statement, end int) {
+	switch statement.(type) {
+	case *BlockStatement, *IfStatement, *WhileStatement, *ForStatement, *FunStatement:
+		return
+	}
+	for f.comment < len(f.program.comments) && f.program.comments[f.comment].offset < end {
+		f.line(f.program.comments[f.comment].lexeme)
+		f.open = false
+		f.comment++
+	}
+}
+
+// trailingComment は直前の文と同じ行にあるコメントを、その文の後ろに出力する
+func (f *formatter) trailingComment() {
+	if f.comment >= len(f.program.comments) {
+		return
+	}
+	comment := f.program.comments[f.comment]
+	if comment.offset < f.last {
+		return
+	}
+	// 間に改行やトークン (閉じ括弧など) があれば、直前の文のコメントではない
+	if strings.Contains(string(f.program.source[f.last:comment.offset]), "\n") ||
+		f.program.tokens[f.program.tokenAt(f.last)].offset < comment.offset {
+		return
+	}
+	f.lines[len(f.lines)-1] += " " + comment.lexeme
+	f.open = false
+	f.last = comment.offset + len(comment.lexeme)
+	f.comment++
+}
+
+// separate は、ソース上で直前の要素との間に空行があれば空行を一つ出力する。
+// 間に `) {` のようなトークンがあるだけの改行は空行と見なさない
+func (f *formatter) separate(start int) {
+	if f.last < 0 || f.last >= start {
+		return
+	}
+	lines := strings.Split(string(f.program.source[f.last:start]), "\n")
+	if len(lines) < 3 {
+		return
+	}
+	// 最初と最後の要素は直前の要素と同じ行、start と同じ行の残り
+	for _, line := range lines[1 : len(lines)-1] {
+		if strings.TrimSpace(line) == "" {
+			f.lines = append(f.lines, "")
+			f.open = false
+			return
+		}
+	}
+}
+
+// line はインデントを付けて一行出力する。
+// 中身の無い { } は `{}` のように一行にまとめる
+func (f *formatter) line(text string) {
+	if f.open && strings.HasPrefix(text, "}") {
+		f.lines[len(f.lines)-1] += text
+	} else {
+		f.lines = append(f.lines, strings.Repeat(formatIndent, f.depth)+text)
+	}
+	f.open = strings.HasSuffix(text, "{")
+}
+
+// body は { } の中身を一段深くして出力する。end は閉じ括弧の位置で、その前のコメントも中に出力する
+func (f *formatter) body(statements []Statement, end int) {
+	f.depth++
+	f.last = -1
+	f.statements(statements, end)
+	f.depth--
+}
+
+func (f *formatter) statement(statement Statement) {
+	p := f.program
+	switch s := statement.(type) {
+	case *BlockStatement:
+		f.line("{")
+		f.body(s.statements, p.spans[s].end)
+		f.line("}")
+	case *IfStatement:
+		keyword := "if"
+		branches := append([]*IfStatement{s}, s.elseIfStatements...)
+		for i, branch := range branches {
+			f.line(keyword + " (" + f.expression(branch.expr) + ") {")
+			// 次の else までが本体
+			end := p.spans[s].end
+			next := p.afterBody(p.spans[branch].start, branch.statements)
+			if p.tokens[next].tokenType == ELSE {
+				end = p.tokens[next].offset
+			}
+			f.body(branch.statements, end)
+			keyword = "} else if"
+			// 空の else も、中にコメントがあるかもしれないので残す
+			if i == len(branches)-1 && p.tokens[next].tokenType == ELSE {
+				f.line("} else {")
+				f.body(s.elseStatements, p.spans[s].end)
+			}
+		}
+		f.line("}")
+	case *WhileStatement:
+		f.line("while (" + f.expression(s.expr) + ") {")
+		f.body(s.statements, p.spans[s].end)
+		f.line("}")
+	case *ForStatement:
+		header := ";"
+		if p.written(s.firstStatement) {
+			header = f.clause(s.firstStatement)
+		}
+		if p.written(s.expression) {
+			header += " " + f.expression(s.expression)
+		}
+		header += ";"
+		if p.written(s.endStatement) {
+			header += " " + strings.TrimSuffix(f.clause(s.endStatement), ";")
+		}
+		f.line("for (" + header + ") {")
+		f.body(s.statements, p.spans[s].end)
+		f.line("}")
+	case *FunStatement:
+		f.line("fun " + s.name + "(" + strings.Join(s.parameters, ", ") + ") {")
+		f.body(s.statements, p.spans[s].end)
+		f.line("}")
+	default:
+		f.line(f.clause(statement))
+	}
+}
+
+// clause は一行で書ける文を `;` まで含めて返す
+func (f *formatter) clause(statement Statement) string {
+	switch s := statement.(type) {
+	case *PrintStatement:
+		return "print " + f.expression(s.expr) + ";"
+	case *ExpressionStatement:
+		return f.expression(s.expr) + ";"
+	case *VariableStatement:
+		if !f.program.written(s.expr) {
+			return "var " + s.varName + ";"
+		}
+		return "var " + s.varName + " = " + f.expression(s.expr) + ";"
+	case *ReturnStatement:
+		if !f.program.written(s.expr) {
+			return "return;"
+		}
+		return "return " + f.expression(s.expr) + ";"
+	}
+	return f.program.text(statement)
+}
+
+// expression は式を標準の書式の文字列にする。
+// 括弧は Group として構文木に残っているので、書かれた通りに出力される
+func (f *formatter) expression(expr Node) string {
+	switch e := expr.(type) {
+	case *NilNode, *BooleanNode, *NumberNode, *StringNode, *Interpolation, *IdentifierNode:
+		// リテラルは 0x1F や エスケープのような書き方を変えないように、ソースのまま出力する
+		s := f.program.spans[e]
+		return string(f.program.source[s.start:s.end])
+	case *Group:
+		parts := make([]string, 0, len(e.nodes))
+		for _, node := range e.nodes {
+			parts = append(parts, f.expression(node))
+		}
+		return "(" + strings.Join(parts, ", ") + ")"
+	case *Unary:
+		right := f.expression(e.right)
+		// - -x を --x と書くと別の演算子になってしまう
+		if e.operator.lexeme == "-" && strings.HasPrefix(right, "-") {
+			return "- " + right
+		}
+		return e.operator.lexeme + right
+	case *Binary:
+		return f.expression(e.left) + " " + e.operator.lexeme + " " + f.expression(e.right)
+	case *Ternary:
+		return f.expression(e.condition) + " ? " + f.expression(e.thenBranch) + " : " + f.expression(e.elseBranch)
+	case *FuncNode:
+		arguments := make([]string, 0, len(e.arguments))
+		for _, argument := range e.arguments {
+			arguments = append(arguments, f.expression(argument))
+		}
+		return f.expression(e.callee) + "(" + strings.Join(arguments, ", ") + ")"
+	case *AssignmentNode:
+		return e.varName + " = " + f.expression(e.value)
+	case *UpdateNode:
+		if e.value != nil {
+			return e.varName + " " + e.operator.lexeme + " " + f.expression(e.value)
+		}
+		if e.prefix {
+			return e.operator.lexeme + e.varName
+		}
+		return e.varName + e.operator.lexeme
+	}
+	return f.program.text(expr)
+}
+
+// afterBody は keyword の位置から始まる if / while / for の本体の次のトークンの添字を返す
+func (p *Program) afterBody(keyword int, statements []Statement) int {
+	i := p.tokenAt(keyword) + 1
+	i = p.matching(i) + 1
+	if p.tokens[i].tokenType == LEFT_BRACE {
+		return p.matching(i) + 1
+	}
+	// { } の無い本体は文が一つだけ
+	return p.tokenAt(p.spans[statements[0]].end)
+}
+
+// tokenAt は offset 以降で最初のトークンの添字を返す
+func (p *Program) tokenAt(offset int) int {
+	return sort.Search(len(p.tokens), func(i int) bool { return p.tokens[i].offset >= offset })
+}
+
+// matching は i 番目の開き括弧に対応する閉じ括弧の添字を返す
+func (p *Program) matching(i int) int {
+	open := p.tokens[i].tokenType
+	close := RIGHT_PAREN
+	if open == LEFT_BRACE {
+		close = RIGHT_BRACE
+	}
+	depth := 0
+	for ; i < len(p.tokens)-1; i++ {
+		switch p.tokens[i].tokenType {
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return i
+}
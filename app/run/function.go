@@ -15,4 +15,12 @@ func (f *FunctionValue) getValue(env *Env) EvaluateNode {
 
 func (f *FunctionValue) getType() string {
 	return "function"
-}
\ No newline at end of file
+}
+
+// getArity は関数が受け取る引数の数を返す
+func (f *Function) getArity() int {
+	if f.native != nil {
+		return f.arity
+	}
+	return len(f.parameters)
+}
@@ -0,0 +1,211 @@
+package run
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"time"
+)
+
+// Interpreter は実行中の状態 (グローバル環境とコールスタック) を持つ。
+// Env からは env.interpreter で参照できる
+type Interpreter struct {
+	globals *Env
+	frames  []callFrame
+	policy  Policy
+	// 実行した文の数
+	steps int
+	// Interpret に渡された context。キャンセルされたら実行を止める
+	ctx context.Context
+	// random() が使う乱数生成器
+	random *rand.Rand
+	// readLine() が使う標準入力。最初に呼ばれたときに作る
+	stdin *bufio.Reader
+	// スクリプト名より後ろのコマンドライン引数
+	args []string
+	// --profile のときだけ設定される
+	profiler *profiler
+	// --cover のときだけ設定される
+	coverage *coverageFile
+	// --trace のときだけ設定される
+	tracer *tracer
+	// print の出力先
+	out io.Writer
+}
+
+// Limits は信頼できないスクリプトを実行するための制限。0 の項目は無制限
+type Limits struct {
+	// 関数呼び出しのネストの最大数。超えると "Stack overflow." になる
+	MaxCallDepth int
+	// 実行できる文の最大数。ループの一周も一つと数える
+	MaxSteps int
+}
+
+// DefaultLimits は Go のスタックを使い切る前に再帰を止める程度の制限
+func DefaultLimits() Limits {
+	return Limits{
+		MaxCallDepth: 10000,
+	}
+}
+
+// callFrame は関数呼び出し一回分の情報。
+// line は呼び出し元で関数が呼ばれた行
+type callFrame struct {
+	name string
+	line int
+}
+
+// RuntimeError は実行時エラー。
+// trace には発生時点のスタックトレースが内側の呼び出しから順に入っている
+type RuntimeError struct {
+	message string
+	line    int
+	trace   []string
+}
+
+func (e *RuntimeError) Error() string {
+	return e.message + "\n" + strings.Join(e.trace, "\n")
+}
+
+func NewInterpreter(policy Policy) *Interpreter {
+	interpreter := &Interpreter{
+		policy: policy,
+		ctx:    context.Background(),
+		out:    os.Stdout,
+		random: rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), 0)),
+	}
+	interpreter.globals = NewEnv()
+	interpreter.globals.interpreter = interpreter
+
+	// ポリシーで許可されていない組み込み関数は使えないようにする
+	for _, n := range natives {
+		if !policy.allowsNative(n.name) {
+			delete(interpreter.globals.variables, n.name)
+		}
+	}
+	return interpreter
+}
+
+// ExitError は exit() でスクリプトが終了したことを表す
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit %d", e.Code)
+}
+
+// Interpret は文を順番に実行する。
+// 実行時エラーが起きた場合や ctx がキャンセルされた場合はそこで止めて *RuntimeError を返す。
+// exit() が呼ばれた場合は *ExitError を返す
+func (in *Interpreter) Interpret(ctx context.Context, statements []Statement) (err error) {
+	in.ctx = ctx
+	defer func() {
+		if r := recover(); r != nil {
+			switch r := r.(type) {
+			case *RuntimeError:
+				err = r
+			case *ExitError:
+				err = r
+			default:
+				panic(r)
+			}
+			in.frames = in.frames[:0]
+		}
+	}()
+
+	resolve(statements)
+	for _, statement := range statements {
+		execute(statement, in.globals)
+	}
+	return nil
+}
+
+// execute は文を一つ実行する。文の実行は全てここを通す
+func execute(statement Statement, env *Env) *ReturnError {
+	env.interpreter.tick(env, statement.getLine())
+	if coverage := env.interpreter.coverage; coverage != nil {
+		coverage.hits[statement]++
+	}
+	if tracer := env.interpreter.tracer; tracer != nil {
+		tracer.statement(statement)
+	}
+	if profiler := env.interpreter.profiler; profiler != nil {
+		profiler.enterLine(statement.getLine())
+		defer profiler.exitLine()
+	}
+	return statement.Execute(env)
+}
+
+// tick は文を一つ実行するたびに呼ばれ、実行数の上限とタイムアウトを確認する
+func (in *Interpreter) tick(env *Env, line int) {
+	in.steps++
+	if in.policy.MaxSteps > 0 && in.steps > in.policy.MaxSteps {
+		runtimeError(env, line, "Execution step limit exceeded.")
+	}
+	if in.ctx.Err() != nil {
+		runtimeError(env, line, "Execution timed out.")
+	}
+}
+
+// SetArgs はスクリプトに渡すコマンドライン引数を設定する
+func (in *Interpreter) SetArgs(args []string) {
+	in.args = args
+}
+
+// SetOutput は print の出力先を w にする
+func (in *Interpreter) SetOutput(w io.Writer) {
+	in.out = w
+}
+
+// SeedRandom は random() の乱数列を seed で固定する。テストで結果を再現するために使う
+func (in *Interpreter) SeedRandom(seed uint64) {
+	in.random = rand.New(rand.NewPCG(seed, 0))
+}
+
+func (in *Interpreter) pushFrame(env *Env, name string, line int) {
+	if in.policy.MaxCallDepth > 0 && len(in.frames) >= in.policy.MaxCallDepth {
+		runtimeError(env, line, "Stack overflow.")
+	}
+	in.frames = append(in.frames, callFrame{name: name, line: line})
+}
+
+func (in *Interpreter) popFrame() {
+	in.frames = in.frames[:len(in.frames)-1]
+}
+
+// スタックトレースの先頭と末尾にそれぞれ表示するフレーム数
+const maxTraceFrames = 10
+
+// stackTrace は line で発生したエラーのスタックトレースを作る
+func (in *Interpreter) stackTrace(line int) []string {
+	trace := make([]string, 0, len(in.frames)+1)
+	for i := len(in.frames) - 1; i >= 0; i-- {
+		trace = append(trace, fmt.Sprintf("[line %d] in %s()", line, in.frames[i].name))
+		// 一つ外側のフレームでは関数を呼び出した行で止まっている
+		line = in.frames[i].line
+	}
+	trace = append(trace, fmt.Sprintf("[line %d] in script", line))
+
+	// 再帰が深い場合は途中のフレームを省略する
+	if len(trace) > 2*maxTraceFrames {
+		omitted := len(trace) - 2*maxTraceFrames
+		trace = append(append(trace[:maxTraceFrames:maxTraceFrames],
+			fmt.Sprintf("... %d more frames ...", omitted)),
+			trace[len(trace)-maxTraceFrames:]...)
+	}
+	return trace
+}
+
+// runtimeError は実行時エラーを発生させる。Interpret で recover される
+func runtimeError(env *Env, line int, format string, args ...any) {
+	panic(&RuntimeError{
+		message: fmt.Sprintf(format, args...),
+		line:    line,
+		trace:   env.interpreter.stackTrace(line),
+	})
+}
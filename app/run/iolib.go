@@ -0,0 +1,77 @@
+package run
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// 入出力とプロセスを扱う組み込み関数。
+// ファイルの読み書きは Policy.AllowFS、exit は Policy.AllowProcess が必要
+
+// readLine() は標準入力から一行読み、改行を除いて返す。入力の終わりでは nil を返す
+func nativeReadLine(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	in := env.interpreter
+	if in.stdin == nil {
+		in.stdin = bufio.NewReader(os.Stdin)
+	}
+	text, err := in.stdin.ReadString('\n')
+	if err == io.EOF && text == "" {
+		return EvaluateNode{value: "nil", valueType: NIL}
+	}
+	if err != nil && err != io.EOF {
+		runtimeError(env, line, "Could not read from stdin: %v.", err)
+	}
+	text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+	return stringValue(env, line, text)
+}
+
+func nativeReadFile(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	path := stringArg(env, line, "readFile", args, 0)
+	requireFS(env, line)
+	// 大きすぎるファイルは読み込む前に断る
+	if info, err := os.Stat(path); err == nil {
+		env.interpreter.checkStringSize(env, line, int(info.Size()))
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		runtimeError(env, line, "Could not read file '%s': %v.", path, err)
+	}
+	return stringValue(env, line, string(contents))
+}
+
+func nativeWriteFile(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	path := stringArg(env, line, "writeFile", args, 0)
+	contents := stringArg(env, line, "writeFile", args, 1)
+	requireFS(env, line)
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		runtimeError(env, line, "Could not write file '%s': %v.", path, err)
+	}
+	return EvaluateNode{value: "nil", valueType: NIL}
+}
+
+// args() はスクリプト名より後ろのコマンドライン引数を文字列のリストで返す
+func nativeArgs(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	env.interpreter.checkListSize(env, line, len(env.interpreter.args))
+	items := make([]EvaluateNode, 0, len(env.interpreter.args))
+	for _, arg := range env.interpreter.args {
+		items = append(items, EvaluateNode{value: arg, valueType: STRING})
+	}
+	return listValue(env, line, items)
+}
+
+// exit(code) はスクリプトの実行を止め、code を終了コードにする
+func nativeExit(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	code := integerArg(env, line, "exit", args, 0)
+	if !env.interpreter.policy.AllowProcess {
+		runtimeError(env, line, "Process access is not allowed.")
+	}
+	panic(&ExitError{Code: code})
+}
+
+func requireFS(env *Env, line int) {
+	if !env.interpreter.policy.AllowFS {
+		runtimeError(env, line, "File access is not allowed. Run with --allow-fs to enable it.")
+	}
+}
@@ -0,0 +1,332 @@
+package run
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// lint のルール ID。コメントの `// lint:ignore <rule>` で抑制できる
+const (
+	lintUnusedVariable   = "unused-variable"
+	lintUnusedParameter  = "unused-parameter"
+	lintUnreachable      = "unreachable-code"
+	lintShadowing        = "shadowing"
+	lintUndeclaredGlobal = "undeclared-global"
+	lintWrongArity       = "wrong-arity"
+	lintEmptyBody        = "empty-body"
+)
+
+// LintFinding は lint が見つけた問題一つ分
+type LintFinding struct {
+	Line    int
+	Rule    string
+	Message string
+}
+
+// lintDecl は変数、引数、関数の宣言
+type lintDecl struct {
+	name string
+	// variable, parameter, function, native のどれか
+	kind string
+	line int
+	used bool
+	// 宣言の後で代入されたかどうか。代入された関数は呼び出し先が分からない
+	assigned bool
+	// 関数と組み込み関数の引数の数。それ以外は -1
+	arity int
+}
+
+// lintCall は呼び出し先が名前で分かる関数呼び出し。引数の数は全て読んだ後で確認する
+type lintCall struct {
+	decl      *lintDecl
+	arguments int
+	line      int
+}
+
+type linter struct {
+	program *Program
+	// ブロックや関数ごとのスコープ。トップレベルは globals で扱う
+	scopes   []map[string]*lintDecl
+	globals  map[string]*lintDecl
+	calls    []lintCall
+	findings []LintFinding
+}
+
+// Lint はよくある間違いを探し、行番号順に返す。
+// `// lint:ignore rule` のコメントがある行の問題は報告しない。コメントだけの行なら次の行も報告しない
+func (p *Program) Lint() []LintFinding {
+	l := &linter{program: p, globals: make(map[string]*lintDecl)}
+	for _, n := range natives {
+		l.globals[n.name] = &lintDecl{name: n.name, kind: "native", arity: n.arity}
+	}
+	// グローバル変数は実行時に名前で探すので、後ろで宣言されたものも関数の中から使える
+	for _, statement := range p.statements {
+		switch s := statement.(type) {
+		case *VariableStatement:
+			l.globals[s.varName] = &lintDecl{name: s.varName, kind: "variable", line: s.line, arity: -1}
+		case *FunStatement:
+			l.globals[s.name] = &lintDecl{name: s.name, kind: "function", line: s.line, arity: len(s.parameters)}
+		}
+	}
+
+	l.statements(p.statements)
+
+	for _, call := range l.calls {
+		if call.decl.assigned || call.decl.arity < 0 || call.decl.arity == call.arguments {
+			continue
+		}
+		arguments := "arguments"
+		if call.decl.arity == 1 {
+			arguments = "argument"
+		}
+		l.report(call.line, lintWrongArity, "'%s' expects %d %s, but is called with %d.",
+			call.decl.name, call.decl.arity, arguments, call.arguments)
+	}
+
+	findings := make([]LintFinding, 0, len(l.findings))
+	ignored := p.lintIgnores()
+	for _, finding := range l.findings {
+		if !ignored(finding) {
+			findings = append(findings, finding)
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Line < findings[j].Line })
+	return findings
+}
+
+// lintIgnores は `// lint:ignore` コメントで抑制された問題かどうかを判定する関数を返す。
+// ルール ID を書かなければ全てのルールを抑制する。
+// コメントだけの行に書いた場合は次の行も抑制し、文の後ろに書いた場合はその行だけを抑制する
+func (p *Program) lintIgnores() func(LintFinding) bool {
+	ignores := make(map[int][]string)
+	for _, comment := range p.comments {
+		text := strings.TrimSpace(strings.TrimPrefix(comment.lexeme, "//"))
+		rules, ok := strings.CutPrefix(text, "lint:ignore")
+		if !ok {
+			continue
+		}
+		names := strings.FieldsFunc(rules, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		if len(names) == 0 {
+			names = []string{""}
+		}
+		ignores[comment.line] = append(ignores[comment.line], names...)
+		lineStart := strings.LastIndexByte(string(p.source[:comment.offset]), '\n') + 1
+		if strings.TrimSpace(string(p.source[lineStart:comment.offset])) == "" {
+			ignores[comment.line+1] = append(ignores[comment.line+1], names...)
+		}
+	}
+	return func(finding LintFinding) bool {
+		for _, rule := range ignores[finding.Line] {
+			if rule == "" || rule == finding.Rule {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (l *linter) report(line int, rule string, format string, args ...any) {
+	l.findings = append(l.findings, LintFinding{Line: line, Rule: rule, Message: fmt.Sprintf(format, args...)})
+}
+
+func (l *linter) pushScope() {
+	l.scopes = append(l.scopes, make(map[string]*lintDecl))
+}
+
+// popScope はスコープを閉じ、一度も読まれなかった変数、引数、関数を報告する。
+// `_` から始まる名前は使わないことを明示したものとして扱う
+func (l *linter) popScope() {
+	scope := l.scopes[len(l.scopes)-1]
+	l.scopes = l.scopes[:len(l.scopes)-1]
+
+	decls := make([]*lintDecl, 0, len(scope))
+	for _, decl := range scope {
+		decls = append(decls, decl)
+	}
+	sort.Slice(decls, func(i, j int) bool { return decls[i].line < decls[j].line })
+	for _, decl := range decls {
+		if decl.used || strings.HasPrefix(decl.name, "_") {
+			continue
+		}
+		switch decl.kind {
+		case "parameter":
+			l.report(decl.line, lintUnusedParameter, "Parameter '%s' is never used.", decl.name)
+		case "function":
+			l.report(decl.line, lintUnusedVariable, "Local function '%s' is never used.", decl.name)
+		default:
+			l.report(decl.line, lintUnusedVariable, "Local variable '%s' is never used.", decl.name)
+		}
+	}
+}
+
+// declare は今のスコープに宣言を追加する。トップレベルの宣言は Lint の最初に登録済み
+func (l *linter) declare(name string, kind string, line int, arity int) {
+	if len(l.scopes) == 0 {
+		return
+	}
+	if outer := l.resolve(name); outer != nil && outer.kind != "native" {
+		if outer.line > 0 {
+			l.report(line, lintShadowing, "'%s' shadows the declaration on line %d.", name, outer.line)
+		} else {
+			l.report(line, lintShadowing, "'%s' shadows an outer declaration.", name)
+		}
+	}
+	l.scopes[len(l.scopes)-1][name] = &lintDecl{name: name, kind: kind, line: line, arity: arity}
+}
+
+// resolve は name を内側のスコープから順に探す。見つからなければ nil
+func (l *linter) resolve(name string) *lintDecl {
+	for i := len(l.scopes) - 1; i >= 0; i-- {
+		if decl, ok := l.scopes[i][name]; ok {
+			return decl
+		}
+	}
+	return l.globals[name]
+}
+
+// assign は変数への代入を記録する。どこにも宣言されていなければ報告する
+func (l *linter) assign(name string, line int) {
+	decl := l.resolve(name)
+	if decl == nil {
+		l.report(line, lintUndeclaredGlobal, "Assignment to undeclared variable '%s'.", name)
+		return
+	}
+	decl.assigned = true
+}
+
+// statements は文を順に調べ、return の後ろにある最初の文を到達しない文として報告する
+func (l *linter) statements(statements []Statement) {
+	for i, statement := range statements {
+		l.statement(statement)
+		if terminates(statement) && i+1 < len(statements) {
+			l.report(statements[i+1].getLine(), lintUnreachable, "Unreachable code after return.")
+			// 到達しない文の中も、他のルールのために調べる
+			for _, rest := range statements[i+1:] {
+				l.statement(rest)
+			}
+			return
+		}
+	}
+}
+
+// block はスコープを一つ作って文を調べる
+func (l *linter) block(statements []Statement) {
+	l.pushScope()
+	l.statements(statements)
+	l.popScope()
+}
+
+// terminates は文を実行すると必ず return するかどうかを返す
+func terminates(statement Statement) bool {
+	switch s := statement.(type) {
+	case *ReturnStatement:
+		return true
+	case *BlockStatement:
+		return slices.ContainsFunc(s.statements, terminates)
+	case *IfStatement:
+		if len(s.elseStatements) == 0 || !slices.ContainsFunc(s.statements, terminates) || !slices.ContainsFunc(s.elseStatements, terminates) {
+			return false
+		}
+		for _, elseIf := range s.elseIfStatements {
+			if !slices.ContainsFunc(elseIf.statements, terminates) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (l *linter) statement(statement Statement) {
+	switch s := statement.(type) {
+	case *PrintStatement:
+		l.expression(s.expr)
+	case *ExpressionStatement:
+		l.expression(s.expr)
+	case *VariableStatement:
+		l.expression(s.expr)
+		l.declare(s.varName, "variable", s.line, -1)
+	case *BlockStatement:
+		l.block(s.statements)
+	case *IfStatement:
+		for _, branch := range append([]*IfStatement{s}, s.elseIfStatements...) {
+			l.expression(branch.expr)
+			if len(branch.statements) == 0 {
+				l.report(branch.line, lintEmptyBody, "Empty if body.")
+			}
+			l.block(branch.statements)
+		}
+		l.block(s.elseStatements)
+	case *WhileStatement:
+		l.expression(s.expr)
+		if len(s.statements) == 0 {
+			l.report(s.line, lintEmptyBody, "Empty while body.")
+		}
+		l.block(s.statements)
+	case *ForStatement:
+		l.pushScope()
+		l.statement(s.firstStatement)
+		l.expression(s.expression)
+		l.block(s.statements)
+		l.statement(s.endStatement)
+		l.popScope()
+	case *FunStatement:
+		l.declare(s.name, "function", s.line, len(s.parameters))
+		l.pushScope()
+		for _, parameter := range s.parameters {
+			l.declare(parameter, "parameter", s.line, -1)
+		}
+		l.statements(s.statements)
+		l.popScope()
+	case *ReturnStatement:
+		l.expression(s.expr)
+	}
+}
+
+func (l *linter) expression(expr Node) {
+	switch e := expr.(type) {
+	case *IdentifierNode:
+		if decl := l.resolve(e.value); decl != nil {
+			decl.used = true
+		}
+	case *AssignmentNode:
+		l.expression(e.value)
+		l.assign(e.varName, e.line)
+	case *UpdateNode:
+		if e.value != nil {
+			l.expression(e.value)
+		}
+		l.assign(e.varName, e.line)
+	case *Interpolation:
+		for _, part := range e.parts {
+			l.expression(part)
+		}
+	case *Group:
+		for _, node := range e.nodes {
+			l.expression(node)
+		}
+	case *Unary:
+		l.expression(e.right)
+	case *Binary:
+		l.expression(e.left)
+		l.expression(e.right)
+	case *Ternary:
+		l.expression(e.condition)
+		l.expression(e.thenBranch)
+		l.expression(e.elseBranch)
+	case *FuncNode:
+		l.expression(e.callee)
+		for _, argument := range e.arguments {
+			l.expression(argument)
+		}
+		if identifier, ok := e.callee.(*IdentifierNode); ok {
+			if decl := l.resolve(identifier.value); decl != nil && decl.arity >= 0 {
+				l.calls = append(l.calls, lintCall{decl: decl, arguments: len(e.arguments), line: e.line})
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package run
+
+import (
+	"strconv"
+	"strings"
+)
+
+// LIST は split() や args() が返すリストの型。
+// リストは作った後で変更できないので、要素は値として共有してよい
+const LIST = "LIST"
+
+// listValue は items を要素とするリストを作る。要素の数がポリシーの上限を超えていればエラーにする
+func listValue(env *Env, line int, items []EvaluateNode) EvaluateNode {
+	env.interpreter.checkListSize(env, line, len(items))
+	return EvaluateNode{
+		value:     "<list>",
+		valueType: LIST,
+		list:      items,
+	}
+}
+
+// stringifyList はリストを ["a", 1, nil] の形式にする。文字列の要素は区別できるように引用符で囲む
+func stringifyList(items []EvaluateNode) string {
+	parts := make([]string, 0, len(items))
+	for _, item := range items {
+		if item.valueType == STRING {
+			parts = append(parts, strconv.Quote(item.value))
+		} else {
+			parts = append(parts, stringify(item))
+		}
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// valuesEqual は == の比較。リストは要素を順に比べる
+func valuesEqual(a, b EvaluateNode) bool {
+	if a.valueType == LIST && b.valueType == LIST {
+		if len(a.list) != len(b.list) {
+			return false
+		}
+		for i := range a.list {
+			if !valuesEqual(a.list[i], b.list[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a.value == b.value && a.valueType == b.valueType
+}
+
+// get(list, index) は index 番目の要素を返す
+func nativeGet(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	list := listArg(env, line, "get", args, 0)
+	index := integerArg(env, line, "get", args, 1)
+	if index < 0 || index >= len(list) {
+		runtimeError(env, line, "List index %d is out of range for length %d.", index, len(list))
+	}
+	return list[index]
+}
+
+// listArg は index 番目の引数がリストであることを確認して返す
+func listArg(env *Env, line int, name string, args []EvaluateNode, index int) []EvaluateNode {
+	if args[index].valueType != LIST {
+		runtimeError(env, line, "Argument %d of '%s' must be a list.", index+1, name)
+	}
+	return args[index].list
+}
@@ -0,0 +1,60 @@
+package run
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 数値を扱う組み込み関数
+
+// mathFunc は引数を一つ取る math パッケージの関数を組み込み関数にする
+func mathFunc(name string, fn func(float64) float64) nativeFunc {
+	return func(env *Env, line int, args []EvaluateNode) EvaluateNode {
+		return numberValue(fn(numberArg(env, line, name, args, 0)))
+	}
+}
+
+func nativePow(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	return numberValue(math.Pow(numberArg(env, line, "pow", args, 0), numberArg(env, line, "pow", args, 1)))
+}
+
+func nativeMin(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	return numberValue(math.Min(numberArg(env, line, "min", args, 0), numberArg(env, line, "min", args, 1)))
+}
+
+func nativeMax(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	return numberValue(math.Max(numberArg(env, line, "max", args, 0), numberArg(env, line, "max", args, 1)))
+}
+
+// random() は 0 以上 1 未満の乱数を返す。run --seed で乱数列を固定できる
+func nativeRandom(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	return numberValue(env.interpreter.random.Float64())
+}
+
+// parseNumber(s) は数値リテラルと同じ書式の文字列を数値にする。数値でないか、大きすぎて表せなければ nil を返す
+func nativeParseNumber(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	s := strings.TrimSpace(stringArg(env, line, "parseNumber", args, 0))
+	sign := 1.0
+	if strings.HasPrefix(s, "-") {
+		sign = -1
+		s = s[1:]
+	}
+	if s == "" || s[0] < '0' || '9' < s[0] {
+		return EvaluateNode{value: "nil", valueType: NIL}
+	}
+	end, value, ok := ScanNumber([]byte(s), 0)
+	if end != len(s) || !ok {
+		return EvaluateNode{value: "nil", valueType: NIL}
+	}
+	return numberValue(sign * value)
+}
+
+// numberArg は index 番目の引数が数値であることを確認して返す
+func numberArg(env *Env, line int, name string, args []EvaluateNode, index int) float64 {
+	if args[index].valueType != NUMBER {
+		runtimeError(env, line, "Argument %d of '%s' must be a number.", index+1, name)
+	}
+	num, _ := strconv.ParseFloat(args[index].value, 64)
+	return num
+}
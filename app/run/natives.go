@@ -0,0 +1,84 @@
+package run
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// nativeFunc は Go で実装された組み込み関数。
+// 引数の数は呼び出し前に確認済み。line はエラー報告用の呼び出し位置
+type nativeFunc func(env *Env, line int, args []EvaluateNode) EvaluateNode
+
+type native struct {
+	name  string
+	arity int
+	fn    nativeFunc
+}
+
+// natives は NewEnv でグローバル環境に登録される組み込み関数の一覧
+var natives = []native{
+	{name: "clock", arity: 0, fn: nativeClock},
+
+	// 文字列
+	{name: "len", arity: 1, fn: nativeLen},
+	{name: "substr", arity: 3, fn: nativeSubstr},
+	{name: "indexOf", arity: 2, fn: nativeIndexOf},
+	{name: "split", arity: 2, fn: nativeSplit},
+	{name: "join", arity: 2, fn: nativeJoin},
+	{name: "upper", arity: 1, fn: nativeUpper},
+	{name: "lower", arity: 1, fn: nativeLower},
+	{name: "trim", arity: 1, fn: nativeTrim},
+	{name: "replace", arity: 3, fn: nativeReplace},
+	{name: "startsWith", arity: 2, fn: nativeStartsWith},
+	{name: "chr", arity: 1, fn: nativeChr},
+	{name: "ord", arity: 1, fn: nativeOrd},
+	{name: "str", arity: 1, fn: nativeStr},
+
+	// リスト
+	{name: "get", arity: 2, fn: nativeGet},
+
+	// 数値
+	{name: "sqrt", arity: 1, fn: mathFunc("sqrt", math.Sqrt)},
+	{name: "pow", arity: 2, fn: nativePow},
+	{name: "floor", arity: 1, fn: mathFunc("floor", math.Floor)},
+	{name: "ceil", arity: 1, fn: mathFunc("ceil", math.Ceil)},
+	{name: "round", arity: 1, fn: mathFunc("round", math.Round)},
+	{name: "abs", arity: 1, fn: mathFunc("abs", math.Abs)},
+	{name: "min", arity: 2, fn: nativeMin},
+	{name: "max", arity: 2, fn: nativeMax},
+	{name: "sin", arity: 1, fn: mathFunc("sin", math.Sin)},
+	{name: "cos", arity: 1, fn: mathFunc("cos", math.Cos)},
+	{name: "log", arity: 1, fn: mathFunc("log", math.Log)},
+	{name: "random", arity: 0, fn: nativeRandom},
+	{name: "parseNumber", arity: 1, fn: nativeParseNumber},
+
+	// 入出力
+	{name: "readLine", arity: 0, fn: nativeReadLine},
+	{name: "readFile", arity: 1, fn: nativeReadFile},
+	{name: "writeFile", arity: 2, fn: nativeWriteFile},
+	{name: "args", arity: 0, fn: nativeArgs},
+	{name: "exit", arity: 1, fn: nativeExit},
+}
+
+// defineNatives は組み込み関数を環境に登録する
+func defineNatives(env *Env) {
+	for _, n := range natives {
+		env.Define(n.name, EvaluateNode{
+			value:     "<native fn>",
+			valueType: "function",
+			function: &Function{
+				name:   n.name,
+				arity:  n.arity,
+				native: n.fn,
+			},
+		})
+	}
+}
+
+func nativeClock(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	return EvaluateNode{
+		value:     strconv.FormatInt(time.Now().Unix(), 10),
+		valueType: NUMBER,
+	}
+}
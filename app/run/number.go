@@ -0,0 +1,116 @@
+package run
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ScanNumber は source[start] の数字から始まる数値リテラルを読み、
+// リテラルの直後の位置と値を返す。
+// 0xFF, 0b1010, 0o17, 1_000_000, 6.02e23 の形式に対応する。
+// リテラルとして解釈できない文字の手前で止まるので、`1.` や `1e` の残りは別のトークンになる。
+// 値が大きすぎて float64 で表せない (1e400 など) 場合は ok が false になる
+func ScanNumber(source []byte, start int) (end int, value float64, ok bool) {
+	i := start
+	if source[i] == '0' && i+2 < len(source) {
+		base := 0
+		switch source[i+1] {
+		case 'x', 'X':
+			base = 16
+		case 'b', 'B':
+			base = 2
+		case 'o', 'O':
+			base = 8
+		}
+		if base != 0 && digitValue(source[i+2]) < base {
+			end = scanDigits(source, i+2, base)
+			for _, c := range source[i+2 : end] {
+				if c != '_' {
+					value = value*float64(base) + float64(digitValue(c))
+				}
+			}
+			return end, value, !math.IsInf(value, 0)
+		}
+	}
+
+	i = scanDigits(source, i, 10)
+	if i+1 < len(source) && source[i] == '.' && digitValue(source[i+1]) < 10 {
+		i = scanDigits(source, i+1, 10)
+	}
+	if i < len(source) && (source[i] == 'e' || source[i] == 'E') {
+		j := i + 1
+		if j < len(source) && (source[j] == '+' || source[j] == '-') {
+			j++
+		}
+		if j < len(source) && digitValue(source[j]) < 10 {
+			i = scanDigits(source, j, 10)
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.ReplaceAll(string(source[start:i]), "_", ""), 64)
+	return i, value, err == nil
+}
+
+// scanDigits は source[i] から base 進数の数字を読み、読み終わった位置を返す。
+// `_` は数字と数字の間にある場合だけ区切りとして読む
+func scanDigits(source []byte, i int, base int) int {
+	i++
+	for i < len(source) {
+		if digitValue(source[i]) < base {
+			i++
+		} else if source[i] == '_' && i+1 < len(source) && digitValue(source[i+1]) < base {
+			i += 2
+		} else {
+			break
+		}
+	}
+	return i
+}
+
+// digitValue は 36 進数までの数字としての値を返す。数字でなければ 36 を返す
+func digitValue(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'z':
+		return int(c-'a') + 10
+	case 'A' <= c && c <= 'Z':
+		return int(c-'A') + 10
+	}
+	return 36
+}
+
+// FormatNumber は数値の正規化した文字列表現を返す。
+// 整数は小数点なし、極端に大きい数と小さい数だけ指数表記にする
+func FormatNumber(num float64) string {
+	switch {
+	case math.IsNaN(num):
+		return "NaN"
+	case math.IsInf(num, 1):
+		return "Infinity"
+	case math.IsInf(num, -1):
+		return "-Infinity"
+	}
+	abs := math.Abs(num)
+	if abs >= 1e21 || (abs != 0 && abs < 1e-7) {
+		// 1e-08 ではなく 1e-8 と表示する
+		formatted := strconv.FormatFloat(num, 'g', -1, 64)
+		formatted = strings.Replace(formatted, "e-0", "e-", 1)
+		return strings.Replace(formatted, "e+0", "e+", 1)
+	}
+	return strconv.FormatFloat(num, 'f', -1, 64)
+}
+
+// FormatLiteral は数値リテラルの値を tokenize と parse の出力形式にする。
+// 整数は 42.0 のように小数点付きで、極端に大きい数と小さい数は指数表記で出力する
+func FormatLiteral(num float64) string {
+	abs := math.Abs(num)
+	if abs >= 1e21 || (abs != 0 && abs < 1e-7) {
+		return strconv.FormatFloat(num, 'g', -1, 64)
+	}
+	if math.Mod(num, 1) == 0 {
+		return strconv.FormatFloat(num, 'f', -1, 64) + ".0"
+	}
+	return strconv.FormatFloat(num, 'f', -1, 64)
+}
@@ -0,0 +1,214 @@
+package run
+
+// optimize は実行前に構文木を書き換える。
+//   - 定数だけの算術、文字列の連結、比較を計算済みのリテラルにする
+//   - 条件が定数の if は選ばれる分岐だけを残し、while (false) と for (...; false; ...) を取り除く
+//
+// 計算すると実行時エラーになる式 (1 / 0 など) はそのまま残し、実行時に同じエラーを出す
+func (in *Interpreter) optimize(statements []Statement) []Statement {
+	o := &optimizer{env: in.globals}
+	return o.statements(statements)
+}
+
+type optimizer struct {
+	// 定数の計算に使う環境。変数は参照しないが、文字列の長さの制限を確認するために使う
+	env *Env
+}
+
+func (o *optimizer) statements(statements []Statement) []Statement {
+	result := make([]Statement, 0, len(statements))
+	for _, statement := range statements {
+		if optimized := o.statement(statement); optimized != nil {
+			result = append(result, optimized)
+		}
+	}
+	return result
+}
+
+// statement は文を最適化する。文が丸ごと取り除かれる場合は nil を返す
+func (o *optimizer) statement(statement Statement) Statement {
+	switch s := statement.(type) {
+	case *PrintStatement:
+		s.expr = o.expression(s.expr)
+	case *ExpressionStatement:
+		s.expr = o.expression(s.expr)
+	case *VariableStatement:
+		s.expr = o.expression(s.expr)
+	case *ReturnStatement:
+		s.expr = o.expression(s.expr)
+	case *BlockStatement:
+		s.statements = o.statements(s.statements)
+	case *FunStatement:
+		s.statements = o.statements(s.statements)
+	case *IfStatement:
+		return o.ifStatement(s)
+	case *WhileStatement:
+		s.expr = o.expression(s.expr)
+		if value, ok := constant(s.expr); ok && !isTrueString(value.value) {
+			return nil
+		}
+		s.statements = o.statements(s.statements)
+	case *ForStatement:
+		s.firstStatement = o.statement(s.firstStatement)
+		s.expression = o.expression(s.expression)
+		if value, ok := constant(s.expression); ok && !isTrueString(value.value) {
+			// 初期化の文は一度だけ実行されるので、for と同じスコープのブロックとして残す
+			if s.firstStatement == nil {
+				return nil
+			}
+			return &BlockStatement{statements: []Statement{s.firstStatement}, line: s.line}
+		}
+		if s.firstStatement == nil {
+			s.firstStatement = &ExpressionStatement{expr: &NilNode{value: "nil", tokenType: NIL}, line: s.line}
+		}
+		s.endStatement = o.statement(s.endStatement)
+		if s.endStatement == nil {
+			s.endStatement = &ExpressionStatement{expr: &NilNode{value: "nil", tokenType: NIL}, line: s.line}
+		}
+		s.statements = o.statements(s.statements)
+	}
+	return statement
+}
+
+// ifStatement は条件が定数の分岐を取り除く。
+// 条件が真の分岐が見つかればそれ以降の分岐は実行されないので、その分岐を else として残す
+func (o *optimizer) ifStatement(s *IfStatement) Statement {
+	branches := make([]*IfStatement, 0, len(s.elseIfStatements)+1)
+	elseStatements := s.elseStatements
+	for _, branch := range append([]*IfStatement{s}, s.elseIfStatements...) {
+		branch.expr = o.expression(branch.expr)
+		value, ok := constant(branch.expr)
+		if !ok {
+			branches = append(branches, branch)
+			continue
+		}
+		if isTrueString(value.value) {
+			elseStatements = branch.statements
+			break
+		}
+	}
+	elseStatements = o.statements(elseStatements)
+
+	if len(branches) == 0 {
+		// 実行される分岐が決まっている。if の本体と同じくブロックのスコープで実行する
+		if len(elseStatements) == 0 {
+			return nil
+		}
+		return &BlockStatement{statements: elseStatements, line: s.line}
+	}
+	for _, branch := range branches {
+		branch.statements = o.statements(branch.statements)
+	}
+	return &IfStatement{
+		expr:             branches[0].expr,
+		statements:       branches[0].statements,
+		elseIfStatements: branches[1:],
+		elseStatements:   elseStatements,
+		line:             branches[0].line,
+	}
+}
+
+func (o *optimizer) expression(expr Node) Node {
+	switch e := expr.(type) {
+	case *Group:
+		for i, node := range e.nodes {
+			e.nodes[i] = o.expression(node)
+		}
+		if len(e.nodes) == 1 {
+			if _, ok := constant(e.nodes[0]); ok {
+				return e.nodes[0]
+			}
+		}
+	case *Unary:
+		e.right = o.expression(e.right)
+		if _, ok := constant(e.right); ok {
+			return o.fold(e)
+		}
+	case *Binary:
+		e.left = o.expression(e.left)
+		e.right = o.expression(e.right)
+		left, leftOk := constant(e.left)
+		_, rightOk := constant(e.right)
+		// and / or は左辺だけで結果が決まることがある
+		if leftOk && e.operator.tokenType == OR && isTrueString(left.value) {
+			return e.left
+		}
+		if leftOk && e.operator.tokenType == AND && !isTrueString(left.value) {
+			return &BooleanNode{value: "false", tokenType: FALSE}
+		}
+		if leftOk && rightOk {
+			return o.fold(e)
+		}
+	case *Ternary:
+		e.condition = o.expression(e.condition)
+		e.thenBranch = o.expression(e.thenBranch)
+		e.elseBranch = o.expression(e.elseBranch)
+		if condition, ok := constant(e.condition); ok {
+			if isTrueString(condition.value) {
+				return e.thenBranch
+			}
+			return e.elseBranch
+		}
+	case *Interpolation:
+		folded := true
+		for i, part := range e.parts {
+			e.parts[i] = o.expression(part)
+			if _, ok := constant(e.parts[i]); !ok {
+				folded = false
+			}
+		}
+		if folded {
+			return o.fold(e)
+		}
+	case *FuncNode:
+		e.callee = o.expression(e.callee)
+		for i, argument := range e.arguments {
+			e.arguments[i] = o.expression(argument)
+		}
+	case *AssignmentNode:
+		e.value = o.expression(e.value)
+	case *UpdateNode:
+		if e.value != nil {
+			e.value = o.expression(e.value)
+		}
+	}
+	return expr
+}
+
+// fold は定数だけの式を評価してリテラルにする。
+// 評価が実行時エラーになる場合は、実行時に同じエラーを出すために expr をそのまま返す
+func (o *optimizer) fold(expr Node) (result Node) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(*RuntimeError); !ok {
+				panic(r)
+			}
+			result = expr
+		}
+	}()
+
+	value := expr.getValue(o.env)
+	switch value.valueType {
+	case NUMBER:
+		return &NumberNode{value: value.value, tokenType: NUMBER}
+	case STRING:
+		return &StringNode{value: value.value, tokenType: STRING}
+	case BOOLEAN:
+		if value.value == "true" {
+			return &BooleanNode{value: "true", tokenType: TRUE}
+		}
+		return &BooleanNode{value: "false", tokenType: FALSE}
+	case NIL:
+		return &NilNode{value: "nil", tokenType: NIL}
+	}
+	return expr
+}
+
+// constant は node がリテラルであればその値を返す
+func constant(node Node) (EvaluateNode, bool) {
+	switch n := node.(type) {
+	case *NumberNode, *StringNode, *BooleanNode, *NilNode:
+		return n.getValue(nil), true
+	}
+	return EvaluateNode{}, false
+}
@@ -3,183 +3,259 @@ package run
 import (
 	"fmt"
 	"os"
+	"slices"
 )
 
+// ParseError は構文エラーを表す。
+// リファレンス実装の Lox と同じ `[line N] Error at 'x': message` の形式で出力する。
+type ParseError struct {
+	token   Token
+	message string
+}
 
+func (e *ParseError) Error() string {
+	if e.token.tokenType == EOF {
+		return fmt.Sprintf("[line %d] Error at end: %s", e.token.line, e.message)
+	}
+	return fmt.Sprintf("[line %d] Error at '%s': %s", e.token.line, e.token.lexeme, e.message)
+}
 
 // parse して構文木を作成する
-func (p *Parser) parseStatements() []Statement {
-	statements := make([]Statement, 0)
-	for p.index < len(p.tokens) && p.tokens[p.index].tokenType != EOF {
-		statement, err := p.parseStatement()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err) // Changed from os.Err(err) to fmt.Fprintln
-			os.Exit(65)
-		}
-		if statement == nil {
-			fmt.Fprintf(os.Stderr, "Error parsing statement at index %d\n", p.index)
-			panic("error while parsing")
+// 構文エラーがあっても文の境界まで読み飛ばしてパースを続けるので、
+// 呼び出し側は p.errors を確認すること
+func (p *Parser) parseStatements() (statements []Statement) {
+	statements = make([]Statement, 0)
+	defer p.recoverNesting()
+	for !p.isAtEnd() {
+		statement := p.parseDeclaration()
+		if statement != nil {
+			statements = append(statements, statement)
 		}
-		statements = append(statements, statement)
 	}
 	return statements
 }
 
-func (p *Parser) parseStatement() (Statement, error) {
-	if p.index >= len(p.tokens) {
-		panic("Index out of range")
-	} else if p.tokens[p.index].tokenType == IF {
-		p.index++
-		if p.tokens[p.index].tokenType != LEFT_PAREN {
-			fmt.Fprintln(os.Stderr, "Missing left parenthesis")
-			os.Exit(65)
-		}
-		p.index++
-		expr, err := p.parseAssignment()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(65)
+// parseDeclaration は文を一つパースする。
+// 構文エラーの場合はエラーを報告し、次の文の境界まで読み飛ばして nil を返す
+func (p *Parser) parseDeclaration() Statement {
+	statement, err := p.parseStatement()
+	if err != nil {
+		p.report(err)
+		p.synchronize()
+		return nil
+	}
+	return statement
+}
+
+// report は構文エラーを記録して出力する。読み飛ばさずにパースを続けられるエラーにも使う
+func (p *Parser) report(err error) {
+	p.errors = append(p.errors, err)
+	fmt.Fprintln(os.Stderr, err)
+}
+
+// synchronize はエラーの後、次の文の先頭と思われる位置までトークンを読み飛ばす
+func (p *Parser) synchronize() {
+	p.advance()
+	for !p.isAtEnd() {
+		if p.tokens[p.index-1].tokenType == SEMICOLON {
+			return
 		}
-		if p.tokens[p.index].tokenType != RIGHT_PAREN {
-			fmt.Fprintln(os.Stderr, "Missing right parenthesis")
-			os.Exit(65)
+		switch p.tokens[p.index].tokenType {
+		case CLASS, FUN, VAR, FOR, IF, WHILE, PRINT, RETURN:
+			return
 		}
+		p.advance()
+	}
+}
+
+func (p *Parser) isAtEnd() bool {
+	return p.index >= len(p.tokens) || p.tokens[p.index].tokenType == EOF
+}
+
+func (p *Parser) check(tokenType string) bool {
+	return !p.isAtEnd() && p.tokens[p.index].tokenType == tokenType
+}
+
+// advance は現在のトークンを返して次に進む。EOF より先には進まない
+// peek は現在の次のトークンを返す。現在のトークンが EOF なら EOF を返す
+func (p *Parser) peek() Token {
+	if p.index+1 >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.index+1]
+}
+
+func (p *Parser) advance() Token {
+	token := p.tokens[p.index]
+	if !p.isAtEnd() {
 		p.index++
-		isBlock := false
-		if p.tokens[p.index].tokenType == LEFT_BRACE {
-			p.index++
-			isBlock = true
+	}
+	return token
+}
+
+// consume は現在のトークンが tokenType であれば読み進め、そうでなければ構文エラーを返す
+func (p *Parser) consume(tokenType string, message string) (Token, error) {
+	if p.check(tokenType) {
+		return p.advance(), nil
+	}
+	return Token{}, p.errorAt(p.tokens[p.index], message)
+}
+
+// mark は start 番目のトークンから直前に読んだトークンまでを、node のソース上の範囲として記録する
+func (p *Parser) mark(node any, start int) {
+	if p.spans == nil || p.index <= start {
+		return
+	}
+	last := p.tokens[p.index-1]
+	p.spans[node] = span{start: p.tokens[start].offset, end: last.offset + len(last.lexeme)}
+}
+
+// node は mark してから node をそのまま返す
+func (p *Parser) node(start int, node Node) Node {
+	p.mark(node, start)
+	return node
+}
+
+// maxNesting は式と文の入れ子の最大の深さ。
+// 構文木を辿る処理 (パース、解決、最適化、評価) はどれも再帰なので、
+// 深すぎる入れ子で Go のスタックを使い切る前に構文エラーにする
+const maxNesting = 256
+
+// nestingError は入れ子が深すぎることを表す構文エラー。
+// 読み飛ばしても閉じ括弧の対応が取れないので、文の境界から再開せずにパースをやめる
+type nestingError struct {
+	err error
+}
+
+// nest は入れ子を一段深くする。深すぎれば nestingError で recoverNesting まで戻る。
+// 呼び出す関数では先に `defer p.unnest(p.depth)` で戻る深さを決めておく。
+// 左結合の二項演算や f(a)(b) の連鎖は再帰せずにループでパースするが、
+// 構文木はその分だけ深くなるので、ループの一周ごとにも nest する
+func (p *Parser) nest() {
+	p.depth++
+	if p.depth > maxNesting {
+		panic(nestingError{p.errorAt(p.tokens[p.index], "Too much nesting.")})
+	}
+}
+
+// recoverNesting は nestingError を構文エラーとして報告する。パースを始める関数で defer する
+func (p *Parser) recoverNesting() {
+	if r := recover(); r != nil {
+		e, ok := r.(nestingError)
+		if !ok {
+			panic(r)
 		}
+		p.report(e.err)
+	}
+}
 
-		statements := make([]Statement, 0)
-		if isBlock {
-			for p.index < len(p.tokens) &&
-				p.tokens[p.index].tokenType != RIGHT_BRACE &&
-				p.tokens[p.index].tokenType != EOF &&
-				p.tokens[p.index].tokenType != ELSE {
+func (p *Parser) unnest(depth int) {
+	p.depth = depth
+}
 
-				statement, _ := p.parseStatement()
-				if statement == nil {
-					fmt.Fprintf(os.Stderr, "Error parsing statement at index %d\n", p.index)
-					panic("error while parsing")
-				}
-				statements = append(statements, statement)
-			}
-			if p.index >= len(p.tokens) || p.tokens[p.index].tokenType != RIGHT_BRACE {
-				fmt.Fprintln(os.Stderr, "Missing right brace")
-				os.Exit(65)
-			}
-			p.index++
-		} else {
-			statement, _ := p.parseStatement()
-			if statement == nil {
-				fmt.Fprintf(os.Stderr, "Error parsing statement at index %d\n", p.index)
-				panic("error while parsing")
-			}
+func (p *Parser) errorAt(token Token, message string) error {
+	return &ParseError{token: token, message: message}
+}
+
+// parseBlock は `{` の後から対応する `}` までの文をパースする
+func (p *Parser) parseBlock() ([]Statement, error) {
+	statements := make([]Statement, 0)
+	for !p.check(RIGHT_BRACE) && !p.isAtEnd() {
+		statement := p.parseDeclaration()
+		if statement != nil {
 			statements = append(statements, statement)
 		}
+	}
+	if _, err := p.consume(RIGHT_BRACE, "Expect '}' after block."); err != nil {
+		return nil, err
+	}
+	return statements, nil
+}
+
+// parseBody は if / while / for の本体をパースする。
+// ブロックの場合はブロック内の文をそのまま返す
+func (p *Parser) parseBody() ([]Statement, error) {
+	if p.check(LEFT_BRACE) {
+		p.advance()
+		return p.parseBlock()
+	}
+	statement, err := p.parseStatement()
+	if err != nil {
+		return nil, err
+	}
+	return []Statement{statement}, nil
+}
+
+// parseCondition は `(` 式 `)` の形の条件式をパースする
+func (p *Parser) parseCondition(keyword string, closeMessage string) (Node, error) {
+	if _, err := p.consume(LEFT_PAREN, "Expect '(' after '"+keyword+"'."); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseAssignment()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.consume(RIGHT_PAREN, closeMessage); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+func (p *Parser) parseStatement() (statement Statement, err error) {
+	if p.index >= len(p.tokens) {
+		panic("Index out of range")
+	}
+	defer p.unnest(p.depth)
+	p.nest()
+	line := p.tokens[p.index].line
+	start := p.index
+	defer func() {
+		if err == nil {
+			p.mark(statement, start)
+		}
+	}()
+	if p.tokens[p.index].tokenType == IF {
+		p.index++
+		expr, err := p.parseCondition("if", "Expect ')' after if condition.")
+		if err != nil {
+			return nil, err
+		}
+		statements, err := p.parseBody()
+		if err != nil {
+			return nil, err
+		}
 
 		elseStatements := make([]Statement, 0)
-		elseIfStatements := make([]IfStatement, 0)
-		for p.index < len(p.tokens) && p.tokens[p.index].tokenType == ELSE {
+		elseIfStatements := make([]*IfStatement, 0)
+		for p.check(ELSE) {
 			p.index++
-			elseBlock := false
-			if p.index >= len(p.tokens) {
-				fmt.Fprintln(os.Stderr, "Missing else block")
-				os.Exit(65)
-			}
-			if p.tokens[p.index].tokenType == IF {
+			if p.check(IF) {
 				// else if の場合
+				elseIfLine := p.tokens[p.index].line
+				elseIfStart := p.index
 				p.index++
-
-				if p.tokens[p.index].tokenType != LEFT_PAREN {
-					fmt.Fprintln(os.Stderr, "Missing left parenthesis")
-					os.Exit(65)
-				}
-				p.index++
-				elseIfExpr, err := p.parseAssignment()
+				elseIfExpr, err := p.parseCondition("if", "Expect ')' after if condition.")
 				if err != nil {
-					fmt.Fprintln(os.Stderr, err)
-					os.Exit(65)
-				}
-				if p.tokens[p.index].tokenType != RIGHT_PAREN {
-					fmt.Fprintln(os.Stderr, "Missing right parenthesis")
-					os.Exit(65)
-				}
-				p.index++
-				isBlock := false
-				if p.tokens[p.index].tokenType == LEFT_BRACE {
-					p.index++
-					isBlock = true
+					return nil, err
 				}
-				tmpStatements := make([]Statement, 0)
-				if isBlock {
-					for p.index < len(p.tokens) &&
-						p.tokens[p.index].tokenType != RIGHT_BRACE &&
-						p.tokens[p.index].tokenType != EOF &&
-						p.tokens[p.index].tokenType != ELSE {
-						statement, _ := p.parseStatement()
-						if statement == nil {
-							fmt.Fprintf(os.Stderr, "Error parsing statement at index %d\n", p.index)
-							panic("error while parsing")
-						}
-						tmpStatements = append(tmpStatements, statement)
-					}
-					if p.index >= len(p.tokens) || p.tokens[p.index].tokenType != RIGHT_BRACE {
-						fmt.Fprintln(os.Stderr, "Missing right brace")
-						os.Exit(65)
-					}
-					p.index++
-				} else {
-					if p.index < len(p.tokens) &&
-						p.tokens[p.index].tokenType != RIGHT_BRACE &&
-						p.tokens[p.index].tokenType != EOF &&
-						p.tokens[p.index].tokenType != ELSE {
-						statement, _ := p.parseStatement()
-						if statement == nil {
-							fmt.Fprintf(os.Stderr, "Error parsing statement at index %d\n", p.index)
-							panic("error while parsing")
-						}
-						tmpStatements = append(tmpStatements, statement)
-					}
+				tmpStatements, err := p.parseBody()
+				if err != nil {
+					return nil, err
 				}
-				elseIfStatements = append(elseIfStatements, IfStatement{
+				elseIfStatement := &IfStatement{
 					expr:       elseIfExpr,
 					statements: tmpStatements,
-				})
+					line:       elseIfLine,
+				}
+				p.mark(elseIfStatement, elseIfStart)
+				elseIfStatements = append(elseIfStatements, elseIfStatement)
 			} else {
 				//ただの else の場合
-				if p.tokens[p.index].tokenType == LEFT_BRACE {
-					p.index++
-					elseBlock = true
-					for p.index < len(p.tokens) &&
-						p.tokens[p.index].tokenType != RIGHT_BRACE &&
-						p.tokens[p.index].tokenType != EOF &&
-						p.tokens[p.index].tokenType != ELSE {
-						statement, _ := p.parseStatement()
-						if statement == nil {
-							fmt.Fprintf(os.Stderr, "Error parsing statement at index %d\n", p.index)
-							panic("error while parsing")
-						}
-						elseStatements = append(elseStatements, statement)
-					}
-				} else {
-					if p.index < len(p.tokens) && p.tokens[p.index].tokenType != SEMICOLON && p.tokens[p.index].tokenType != EOF {
-						statement, _ := p.parseStatement()
-						if statement == nil {
-							fmt.Fprintf(os.Stderr, "Error parsing statement at index %d\n", p.index)
-							panic("error while parsing")
-						}
-						elseStatements = append(elseStatements, statement)
-					}
-				}
-				if elseBlock {
-					if p.index >= len(p.tokens) || p.tokens[p.index].tokenType != RIGHT_BRACE {
-						fmt.Fprintln(os.Stderr, "Missing right brace")
-						os.Exit(65)
-					}
-					p.index++
+				elseStatements, err = p.parseBody()
+				if err != nil {
+					return nil, err
 				}
+				break
 			}
 		}
 
@@ -188,206 +264,128 @@ func (p *Parser) parseStatement() (Statement, error) {
 			statements:       statements,
 			elseStatements:   elseStatements,
 			elseIfStatements: elseIfStatements,
+			line:             line,
 		}, nil
 	} else if p.tokens[p.index].tokenType == LEFT_BRACE {
 		p.index++
-		statements := make([]Statement, 0)
-		for p.index < len(p.tokens) && p.tokens[p.index].tokenType != RIGHT_BRACE && p.tokens[p.index].tokenType != EOF {
-			statement, _ := p.parseStatement()
-			if statement == nil {
-				fmt.Fprintf(os.Stderr, "Error parsing statement at index %d\n", p.index)
-				panic("error while parsing")
-			}
-			statements = append(statements, statement)
-		}
-		if p.index >= len(p.tokens) || p.tokens[p.index].tokenType != RIGHT_BRACE {
-			fmt.Fprintln(os.Stderr, "Missing right brace")
-			os.Exit(65)
+		statements, err := p.parseBlock()
+		if err != nil {
+			return nil, err
 		}
-		p.index++
 		return &BlockStatement{
 			statements: statements,
+			line:       line,
 		}, nil
 	} else if p.tokens[p.index].tokenType == PRINT {
 		p.index++
-		if p.tokens[p.index].value == ";" {
-			fmt.Fprintln(os.Stderr, "Missing expression after print")
-			os.Exit(65)
-		}
 		expr, err := p.parseAssignment()
-		if p.tokens[p.index].value == ";" {
-			p.index++
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(65)
-			}
-			return &PrintStatement{
-				expr: expr,
-			}, nil
-		}
-
-		return nil, fmt.Errorf("syntax error")
-	} else if p.tokens[p.index].tokenType == VAR {
-		p.index++
-		varName := p.tokens[p.index].value
-		p.index++
-		if p.tokens[p.index].tokenType == SEMICOLON {
-			p.index++
-
-			return &VariableStatement{
-				expr:    &NilNode{value: "nil", tokenType: NIL},
-				varName: varName,
-			}, nil
+		if err != nil {
+			return nil, err
 		}
-		if p.tokens[p.index].value != "=" {
-			panic("= is missing")
+		if _, err := p.consume(SEMICOLON, "Expect ';' after value."); err != nil {
+			return nil, err
 		}
+		return &PrintStatement{
+			expr: expr,
+			line: line,
+		}, nil
+	} else if p.tokens[p.index].tokenType == VAR {
 		p.index++
-		varValue, err := p.parseAssignment()
+		name, err := p.consume(IDENTIFIER, "Expect variable name.")
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(65)
+			return nil, err
 		}
-		if p.tokens[p.index].value != ";" {
-			panic("; is missing " + p.tokens[p.index].value)
+
+		var varValue Node = &NilNode{value: "nil", tokenType: NIL}
+		if p.check(EQUAL) {
+			p.index++
+			varValue, err = p.parseAssignment()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if _, err := p.consume(SEMICOLON, "Expect ';' after variable declaration."); err != nil {
+			return nil, err
 		}
-		p.index++
 
 		return &VariableStatement{
 			expr:    varValue,
-			varName: varName,
+			varName: name.value,
+			line:    line,
 		}, nil
 	} else if p.tokens[p.index].tokenType == WHILE {
 		p.index++
-		if p.tokens[p.index].tokenType != LEFT_PAREN {
-			fmt.Fprintln(os.Stderr, "Missing left parenthesis")
-			os.Exit(65)
-		}
-		p.index++
-		expr, err := p.parseAssignment()
+		expr, err := p.parseCondition("while", "Expect ')' after condition.")
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(65)
-		}
-		if p.tokens[p.index].tokenType != RIGHT_PAREN {
-			fmt.Fprintln(os.Stderr, "Missing right parenthesis")
-			os.Exit(65)
-		}
-		p.index++
-		isBlock := false
-		if p.tokens[p.index].tokenType == LEFT_BRACE {
-			p.index++
-			isBlock = true
+			return nil, err
 		}
-
-		statements := make([]Statement, 0)
-		if isBlock {
-			for p.index < len(p.tokens) &&
-				p.tokens[p.index].tokenType != RIGHT_BRACE &&
-				p.tokens[p.index].tokenType != EOF {
-
-				statement, _ := p.parseStatement()
-				if statement == nil {
-					fmt.Fprintf(os.Stderr, "Error parsing statement at index %d\n", p.index)
-					panic("error while parsing")
-				}
-				statements = append(statements, statement)
-			}
-			if p.index >= len(p.tokens) || p.tokens[p.index].tokenType != RIGHT_BRACE {
-				fmt.Fprintln(os.Stderr, "Missing right brace")
-				os.Exit(65)
-			}
-			p.index++
-		} else {
-			statement, _ := p.parseStatement()
-			if statement == nil {
-				fmt.Fprintf(os.Stderr, "Error parsing statement at index %d\n", p.index)
-				panic("error while parsing")
-			}
-			statements = append(statements, statement)
+		statements, err := p.parseBody()
+		if err != nil {
+			return nil, err
 		}
 
 		return &WhileStatement{
 			expr:       expr,
 			statements: statements,
+			line:       line,
 		}, nil
 	} else if p.tokens[p.index].tokenType == FOR {
 		p.index++
-		if p.tokens[p.index].tokenType != LEFT_PAREN {
-			fmt.Fprintln(os.Stderr, "Missing left parenthesis")
-			os.Exit(65)
+		if _, err := p.consume(LEFT_PAREN, "Expect '(' after 'for'."); err != nil {
+			return nil, err
 		}
 
-		p.index++
-
 		var firstStatement Statement
 		// セミコロンでなければ、最初の文をパースする
-		if p.tokens[p.index].tokenType != SEMICOLON {
-			firstStatement, _ = p.parseStatement()
+		if !p.check(SEMICOLON) {
+			var err error
+			firstStatement, err = p.parseStatement()
+			if err != nil {
+				return nil, err
+			}
 		} else {
 			// セミコロンの場合は、nil を代入する
 			p.index++
 			firstStatement = &ExpressionStatement{
 				expr: &NilNode{value: "nil", tokenType: NIL},
+				line: line,
 			}
 		}
-		expression, err := p.parseAssignment()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(65)
+
+		// 条件が省略された場合は常に true とみなす
+		var expression Node = &BooleanNode{value: "true", tokenType: TRUE}
+		if !p.check(SEMICOLON) {
+			var err error
+			expression, err = p.parseAssignment()
+			if err != nil {
+				return nil, err
+			}
 		}
-		p.index++
-		var endStatement Statement
-		if p.tokens[p.index].tokenType != RIGHT_PAREN {
+		if _, err := p.consume(SEMICOLON, "Expect ';' after loop condition."); err != nil {
+			return nil, err
+		}
+
+		var endStatement Statement = &ExpressionStatement{
+			expr: &NilNode{value: "nil", tokenType: NIL},
+			line: line,
+		}
+		if !p.check(RIGHT_PAREN) {
+			endLine := p.tokens[p.index].line
+			endStart := p.index
 			expr, err := p.parseAssignment()
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(65)
-			}
-			endStatement = &ExpressionStatement{expr: expr}
-			if p.tokens[p.index].tokenType == SEMICOLON {
-				p.index++
-			}
-			p.index++
-		} else {
-			// セミコロンの場合は、nil を代入する
-			p.index++
-			endStatement = &ExpressionStatement{
-				expr: &NilNode{value: "nil", tokenType: NIL},
+				return nil, err
 			}
+			endStatement = &ExpressionStatement{expr: expr, line: endLine}
+			p.mark(endStatement, endStart)
+		}
+		if _, err := p.consume(RIGHT_PAREN, "Expect ')' after for clauses."); err != nil {
+			return nil, err
 		}
 
-		isBlock := false
-
-		if p.tokens[p.index].tokenType == LEFT_BRACE {
-			p.index++
-			isBlock = true
-		}
-		statements := make([]Statement, 0)
-		if isBlock {
-			for p.index < len(p.tokens) &&
-				p.tokens[p.index].tokenType != RIGHT_BRACE &&
-				p.tokens[p.index].tokenType != EOF {
-
-				statement, _ := p.parseStatement()
-				if statement == nil {
-					fmt.Fprintf(os.Stderr, "Error parsing statement at index %d\n", p.index)
-					panic("error while parsing")
-				}
-				statements = append(statements, statement)
-			}
-			if p.index >= len(p.tokens) || p.tokens[p.index].tokenType != RIGHT_BRACE {
-				fmt.Fprintln(os.Stderr, "Missing right brace")
-				os.Exit(65)
-			}
-			p.index++
-		} else {
-			statement, _ := p.parseStatement()
-			if statement == nil {
-				fmt.Fprintf(os.Stderr, "Error parsing statement at index %d\n", p.index)
-				panic("error while parsing")
-			}
-			statements = append(statements, statement)
+		statements, err := p.parseBody()
+		if err != nil {
+			return nil, err
 		}
 
 		return &ForStatement{
@@ -395,90 +393,86 @@ func (p *Parser) parseStatement() (Statement, error) {
 			expression:     expression,
 			endStatement:   endStatement,
 			statements:     statements,
+			line:           line,
 		}, nil
 	} else if p.tokens[p.index].tokenType == FUN {
 		// fun の部分の index を ++ する
 		p.index++
 
-		funName := p.tokens[p.index].value
-
-		p.index++
-
-		if p.tokens[p.index].tokenType != LEFT_PAREN {
-			// 一旦 syntax error にしておく
-			return nil, fmt.Errorf("syntax error")
+		name, err := p.consume(IDENTIFIER, "Expect function name.")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.consume(LEFT_PAREN, "Expect '(' after function name."); err != nil {
+			return nil, err
 		}
 
-		p.index++
 		var parameters []string
-		for p.tokens[p.index].tokenType != RIGHT_PAREN {
-			parameter := p.tokens[p.index].value
-			parameters = append(parameters, parameter)
-			p.index++
-			if p.tokens[p.index].tokenType != COMMA {
-				break
+		if !p.check(RIGHT_PAREN) {
+			for {
+				parameter, err := p.consume(IDENTIFIER, "Expect parameter name.")
+				if err != nil {
+					return nil, err
+				}
+				// 構文としては正しいので、エラーを記録してパースを続ける
+				if slices.Contains(parameters, parameter.value) {
+					p.report(p.errorAt(parameter, "Already a variable with this name in this scope."))
+				}
+				parameters = append(parameters, parameter.value)
+				if !p.check(COMMA) {
+					break
+				}
+				p.index++
 			}
-			p.index++
 		}
-		p.index++
-
-		if p.tokens[p.index].tokenType != LEFT_BRACE {
-			return nil, fmt.Errorf("syntax error")
+		if _, err := p.consume(RIGHT_PAREN, "Expect ')' after parameters."); err != nil {
+			return nil, err
+		}
+		if _, err := p.consume(LEFT_BRACE, "Expect '{' before function body."); err != nil {
+			return nil, err
 		}
 
-		p.index++
-		statements := make([]Statement, 0)
-		for p.index < len(p.tokens) && p.tokens[p.index].tokenType != RIGHT_BRACE {
-			statement, _ := p.parseStatement()
-			if statement == nil {
-				fmt.Fprintf(os.Stderr, "Error parsing statement at index %d\n", p.index)
-				panic("error while parsing")
-			}
-			statements = append(statements, statement)
+		statements, err := p.parseBlock()
+		if err != nil {
+			return nil, err
 		}
-		// to do
-		p.index++
 		return &FunStatement{
-			name:       funName,
+			name:       name.value,
 			parameters: parameters,
 			statements: statements,
+			line:       line,
 		}, nil
 	} else if p.tokens[p.index].tokenType == RETURN {
 		p.index++
-		var expr Node
-		var err error
-		if p.tokens[p.index].tokenType == SEMICOLON {
-			p.index++
-			expr = &NilNode{value: "nil", tokenType: NIL}
-		} else {
+		var expr Node = &NilNode{value: "nil", tokenType: NIL}
+		if !p.check(SEMICOLON) {
+			var err error
 			expr, err = p.parseAssignment()
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(65)
-			}
-			if p.tokens[p.index].tokenType == SEMICOLON {
-				p.index++
+				return nil, err
 			}
 		}
+		if _, err := p.consume(SEMICOLON, "Expect ';' after return value."); err != nil {
+			return nil, err
+		}
 		return &ReturnStatement{
 			expr: expr,
+			line: line,
 		}, nil
 	}
 
 	// ただの式。特に何かをしているわけではない。
 	expression, err := p.parseAssignment()
-	if p.tokens[p.index].tokenType == SEMICOLON {
-		p.index++
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(65)
-		}
-		return &ExpressionStatement{
-			expr: expression,
-		}, nil
+	if err != nil {
+		return nil, err
 	}
-
-	panic("unknown statement")
+	if _, err := p.consume(SEMICOLON, "Expect ';' after expression."); err != nil {
+		return nil, err
+	}
+	return &ExpressionStatement{
+		expr: expression,
+		line: line,
+	}, nil
 }
 
 func (p *Parser) parseAssignment() (Node, error) {
@@ -486,72 +480,99 @@ func (p *Parser) parseAssignment() (Node, error) {
 		panic("Index out of range")
 	}
 	token := p.tokens[p.index]
-
-	if token.tokenType == IDENTIFIER {
-		if p.tokens[p.index+1].value == SEMICOLON {
-			p.index++
-			return &IdentifierNode{
-				value:     token.value,
-				tokenType: token.tokenType,
-			}, nil
+	start := p.index
+	// 右辺のパースは parseUnary を通らずに再帰することがあるので、代入の連鎖も深さに数える
+	defer p.unnest(p.depth)
+
+	next := p.peek()
+
+	if _, ok := updateOperators[next.tokenType]; token.tokenType == IDENTIFIER && ok &&
+		next.tokenType != PLUS_PLUS && next.tokenType != MINUS_MINUS {
+		// x += 1 のような複合代入
+		operator := next
+		p.index += 2
+		p.nest()
+		value, err := p.parseAssignment()
+		if err != nil {
+			return nil, err
 		}
-		if p.tokens[p.index+1].tokenType == EQUAL {
-			p.index++
-			p.index++
-			value, err := p.parseAssignment()
-			if err != nil {
-				return nil, err
-			}
-			return &AssignmentNode{
-				varName:   token.value,
-				value:     value,
-				valueType: ASSIGNMENT,
-			}, nil
+		return p.node(start, &UpdateNode{
+			varName:  token.value,
+			operator: operator,
+			value:    value,
+			line:     token.line,
+		}), nil
+	}
+
+	if token.tokenType == IDENTIFIER && next.tokenType == EQUAL {
+		p.index++
+		p.index++
+		p.nest()
+		value, err := p.parseAssignment()
+		if err != nil {
+			return nil, err
 		}
+		return p.node(start, &AssignmentNode{
+			varName:   token.value,
+			value:     value,
+			valueType: ASSIGNMENT,
+			line:      token.line,
+		}), nil
 	}
 
-	node, err := p.parseExpression()
+	node, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
 	// Identifier でない場合は expression をそのまま返す
 
-	if p.tokens[p.index].tokenType == OR {
-		for p.index < len(p.tokens) && p.tokens[p.index].tokenType == OR {
-			or_token := p.tokens[p.index]
-			p.index++
-			rightNode, err := p.parseExpression()
-			if err != nil {
-				return nil, err
-			}
-			if rightNode == nil {
-				fmt.Fprintln(os.Stderr, "Error parsing right node")
-				os.Exit(65)
-			}
-			node = &Binary{
-				left:      node,
-				operator:  or_token,
-				right:     rightNode,
-				tokenType: OR,
-			}
-		}
-		return node, nil
-	} else if p.tokens[p.index].tokenType == AND {
-		for p.index < len(p.tokens) && p.tokens[p.index].tokenType == AND {
-			and_token := p.tokens[p.index]
-			p.index++
-			rightNode, err := p.parseExpression()
-			if err != nil {
-				return nil, err
-			}
-			node = &Binary{
-				left:      node,
-				operator:  and_token,
-				right:     rightNode,
-				tokenType: AND,
-			}
-		}
-		return node, nil
+	// 変数以外への代入はエラー
+	if p.check(EQUAL) || p.check(PLUS_EQUAL) || p.check(MINUS_EQUAL) || p.check(STAR_EQUAL) ||
+		p.check(SLASH_EQUAL) || p.check(PERCENT_EQUAL) {
+		return nil, p.errorAt(p.tokens[p.index], "Invalid assignment target.")
+	}
+
+	return node, nil
+}
+
+// parseTernary は cond ? a : b をパースする。
+// or より優先順位が低く、右結合なので a ? b : c ? d : e は a ? b : (c ? d : e) になる
+func (p *Parser) parseTernary() (Node, error) {
+	start := p.index
+	condition, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.check(QUESTION) {
+		return condition, nil
 	}
 
-	return node, err
+	question := p.advance()
+	thenBranch, err := p.parseAssignment()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.consume(COLON, "Expect ':' after then branch of conditional expression."); err != nil {
+		return nil, err
+	}
+	elseBranch, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	return p.node(start, &Ternary{
+		condition:  condition,
+		thenBranch: thenBranch,
+		elseBranch: elseBranch,
+		tokenType:  question.tokenType,
+	}), nil
+}
+
+func (p *Parser) parseOr() (Node, error) {
+	return p.parseBinaryLeft(p.parseAnd, OR)
+}
+
+func (p *Parser) parseAnd() (Node, error) {
+	return p.parseBinaryLeft(p.parseExpression, AND)
 }
 
 func (p *Parser) parseExpression() (Node, error) {
@@ -565,17 +586,20 @@ func (p *Parser) parseExpression() (Node, error) {
 }
 
 func (p *Parser) parseEquality() (Node, error) {
+	start := p.index
+	defer p.unnest(p.depth)
 	err := error(nil)
-	left, err := p.parseComparison()
+	left, err := p.parseBitOr()
 
 	if err != nil {
 		return nil, err
 	}
 	token := p.tokens[p.index]
 	for token.tokenType == EQUAL_EQUAL || token.tokenType == BANG_EQUAL {
+		p.nest()
 		var right Node
 		p.index++
-		right, err = p.parseComparison()
+		right, err = p.parseBitOr()
 		if err != nil {
 			return nil, err
 		}
@@ -585,6 +609,7 @@ func (p *Parser) parseEquality() (Node, error) {
 			right:     right,
 			tokenType: token.tokenType,
 		}
+		p.mark(left, start)
 		// 次のループに備えて token を更新する
 		token = p.tokens[p.index]
 	}
@@ -592,19 +617,68 @@ func (p *Parser) parseEquality() (Node, error) {
 	return left, nil
 }
 
+// parseBitOr, parseBitXor, parseBitAnd はビット演算子をパースする。
+// 優先順位は C と同じく | < ^ < & の順に高くなる
+func (p *Parser) parseBitOr() (Node, error) {
+	return p.parseBinaryLeft(p.parseBitXor, PIPE)
+}
+
+func (p *Parser) parseBitXor() (Node, error) {
+	return p.parseBinaryLeft(p.parseBitAnd, CARET)
+}
+
+func (p *Parser) parseBitAnd() (Node, error) {
+	return p.parseBinaryLeft(p.parseComparison, AMPERSAND)
+}
+
+// parseShift は << と >> をパースする。比較演算子より優先順位が高い
+func (p *Parser) parseShift() (Node, error) {
+	return p.parseBinaryLeft(p.parseTerm, LESS_LESS, GREATER_GREATER)
+}
+
+// parseBinaryLeft は operand を operators で繋いだ左結合の二項演算をパースする
+func (p *Parser) parseBinaryLeft(operand func() (Node, error), operators ...string) (Node, error) {
+	start := p.index
+	defer p.unnest(p.depth)
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for slices.Contains(operators, p.tokens[p.index].tokenType) {
+		p.nest()
+		token := p.tokens[p.index]
+		p.index++
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &Binary{
+			left:      left,
+			operator:  token,
+			right:     right,
+			tokenType: token.tokenType,
+		}
+		p.mark(left, start)
+	}
+	return left, nil
+}
+
 func (p *Parser) parseComparison() (Node, error) {
+	start := p.index
+	defer p.unnest(p.depth)
 	err := error(nil)
 	var left Node
-	left, err = p.parseTerm()
+	left, err = p.parseShift()
 	if err != nil {
 		return nil, err
 	}
 	token := p.tokens[p.index]
 	for token.tokenType == LESS || token.tokenType == LESS_EQUAL ||
 		token.tokenType == GREATER || token.tokenType == GREATER_EQUAL {
+		p.nest()
 		var right Node
 		p.index++
-		right, err = p.parseTerm()
+		right, err = p.parseShift()
 		if err != nil {
 			return nil, err
 		}
@@ -614,6 +688,7 @@ func (p *Parser) parseComparison() (Node, error) {
 			right:     right,
 			tokenType: token.tokenType,
 		}
+		p.mark(left, start)
 		// 次のループに備えて token を更新する
 		token = p.tokens[p.index]
 	}
@@ -621,6 +696,8 @@ func (p *Parser) parseComparison() (Node, error) {
 }
 
 func (p *Parser) parseTerm() (Node, error) {
+	start := p.index
+	defer p.unnest(p.depth)
 	err := error(nil)
 	var left Node
 	left, err = p.parseFactor()
@@ -630,6 +707,7 @@ func (p *Parser) parseTerm() (Node, error) {
 	}
 	token := p.tokens[p.index]
 	for token.tokenType == PLUS || token.tokenType == MINUS {
+		p.nest()
 		var right Node
 		p.index++
 		right, err = p.parseFactor()
@@ -642,6 +720,7 @@ func (p *Parser) parseTerm() (Node, error) {
 			right:     right,
 			tokenType: token.tokenType,
 		}
+		p.mark(left, start)
 		// 次のループに備えて token を更新する
 		token = p.tokens[p.index]
 	}
@@ -650,6 +729,8 @@ func (p *Parser) parseTerm() (Node, error) {
 }
 
 func (p *Parser) parseFactor() (Node, error) {
+	start := p.index
+	defer p.unnest(p.depth)
 	err := error(nil)
 	var left Node
 	left, err = p.parseUnary()
@@ -658,7 +739,9 @@ func (p *Parser) parseFactor() (Node, error) {
 	}
 
 	token := p.tokens[p.index]
-	for token.tokenType == STAR || token.tokenType == SLASH {
+	for token.tokenType == STAR || token.tokenType == SLASH ||
+		token.tokenType == PERCENT || token.tokenType == TILDE_SLASH {
+		p.nest()
 		var right Node
 		p.index++
 		right, err = p.parseUnary()
@@ -671,6 +754,7 @@ func (p *Parser) parseFactor() (Node, error) {
 			right:     right,
 			tokenType: token.tokenType,
 		}
+		p.mark(left, start)
 		// 次のループに備えて token を更新する
 		token = p.tokens[p.index]
 	}
@@ -683,94 +767,131 @@ func (p *Parser) parseUnary() (Node, error) {
 	if p.index >= len(p.tokens) {
 		panic("Index out of range")
 	}
+	defer p.unnest(p.depth)
+	p.nest()
 	token := p.tokens[p.index]
-	for token.tokenType == BANG || token.tokenType == MINUS {
+	start := p.index
+	for token.tokenType == BANG || token.tokenType == MINUS || token.tokenType == TILDE {
 		var right Node
 		p.index++
 		right, err = p.parseUnary()
 		if err != nil {
 			return nil, err
 		}
-		return &Unary{
+		return p.node(start, &Unary{
 			operator:  token,
 			right:     right,
 			tokenType: token.tokenType,
-		}, nil
+		}), nil
+	}
+
+	// ++x と --x
+	if token.tokenType == PLUS_PLUS || token.tokenType == MINUS_MINUS {
+		p.index++
+		name, err := p.consume(IDENTIFIER, "Expect variable name after '"+token.value+"'.")
+		if err != nil {
+			return nil, err
+		}
+		return p.node(start, &UpdateNode{
+			varName:  name.value,
+			operator: token,
+			prefix:   true,
+			line:     name.line,
+		}), nil
 	}
 
-	return p.parseCall()
+	return p.parsePower()
 }
 
-func (p *Parser) parseCall() (Node, error) {
-	expr, err := p.parsePrimary()
+// parsePower は `**` をパースする。
+// 右結合で、-2 ** 2 は -(2 ** 2) になる。右辺には 2 ** -1 のように単項演算子を書ける
+func (p *Parser) parsePower() (Node, error) {
+	start := p.index
+	left, err := p.parsePostfix()
 	if err != nil {
 		return nil, err
 	}
 
-	if p.index < len(p.tokens) && p.tokens[p.index].tokenType == LEFT_PAREN {
+	token := p.tokens[p.index]
+	if token.tokenType == STAR_STAR {
 		p.index++
-		args := make([]Node, 0)
-		for p.index < len(p.tokens) && p.tokens[p.index].tokenType != RIGHT_PAREN {
-			arg, err := p.parseAssignment()
-			if err != nil {
-				return nil, err
-			}
-			args = append(args, arg)
-
-			if p.index < len(p.tokens) && p.tokens[p.index].tokenType == COMMA {
-				p.index++
-			}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
 		}
-		if p.index < len(p.tokens) && p.tokens[p.index].tokenType == RIGHT_PAREN {
-			p.index++
-		} else {
-			return nil, fmt.Errorf("missing right parenthesis")
+		return p.node(start, &Binary{
+			left:      left,
+			operator:  token,
+			right:     right,
+			tokenType: token.tokenType,
+		}), nil
+	}
+
+	return left, nil
+}
+
+// parsePostfix は x++ と x-- をパースする
+func (p *Parser) parsePostfix() (Node, error) {
+	start := p.index
+	expr, err := p.parseCall()
+	if err != nil {
+		return nil, err
+	}
+
+	token := p.tokens[p.index]
+	if token.tokenType == PLUS_PLUS || token.tokenType == MINUS_MINUS {
+		identifier, ok := expr.(*IdentifierNode)
+		if !ok {
+			return nil, p.errorAt(token, "Invalid increment target.")
 		}
+		p.index++
+		return p.node(start, &UpdateNode{
+			varName:  identifier.value,
+			operator: token,
+			line:     identifier.line,
+		}), nil
+	}
 
-		chain := false
-		for p.index < len(p.tokens) && p.tokens[p.index].tokenType == LEFT_PAREN {
-			chain = true
-			expr = &FuncNode{
-				callee:    expr,
-				arguments: args,
-				tokenType: FUN,
-			}
-			p.index++
-			args = make([]Node, 0)
-			for p.index < len(p.tokens) && p.tokens[p.index].tokenType != RIGHT_PAREN {
+	return expr, nil
+}
+
+func (p *Parser) parseCall() (Node, error) {
+	start := p.index
+	defer p.unnest(p.depth)
+	expr, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	// f(a)(b) のような関数チェーンにも対応する
+	for p.check(LEFT_PAREN) {
+		p.nest()
+		p.index++
+		args := make([]Node, 0)
+		if !p.check(RIGHT_PAREN) {
+			for {
 				arg, err := p.parseAssignment()
 				if err != nil {
 					return nil, err
 				}
 				args = append(args, arg)
-
-				if p.index < len(p.tokens) && p.tokens[p.index].tokenType == COMMA {
-					p.index++
+				if !p.check(COMMA) {
+					break
 				}
-			}
-			if p.index < len(p.tokens) && p.tokens[p.index].tokenType == RIGHT_PAREN {
 				p.index++
-			} else {
-				return nil, fmt.Errorf("missing right parenthesis")
-			}
-
-			expr = &FuncNode{
-				callee:    expr,
-				arguments: args,
-				tokenType: FUN,
 			}
 		}
-
-		// 関数チェーンの場合はそのまま返す
-		if chain {
-			return expr, nil
+		paren, err := p.consume(RIGHT_PAREN, "Expect ')' after arguments.")
+		if err != nil {
+			return nil, err
 		}
 
-		return &FuncNode{
+		expr = p.node(start, &FuncNode{
 			callee:    expr,
 			arguments: args,
 			tokenType: FUN,
-		}, nil
+			line:      paren.line,
+		})
 	}
 
 	return expr, nil
@@ -778,62 +899,92 @@ func (p *Parser) parseCall() (Node, error) {
 
 func (p *Parser) parsePrimary() (Node, error) {
 	token := p.tokens[p.index]
+	start := p.index
 
 	if token.tokenType == NIL {
 		p.index++
-		return &NilNode{
+		return p.node(start, &NilNode{
 			value:     token.value,
 			tokenType: token.tokenType,
-		}, nil
+		}), nil
 	}
 
 	if token.tokenType == TRUE || token.tokenType == FALSE {
 		p.index++
-		return &BooleanNode{
+		return p.node(start, &BooleanNode{
 			value:     token.value,
 			tokenType: token.tokenType,
-		}, nil
+		}), nil
 	}
 
 	if token.tokenType == NUMBER {
 		p.index++
-		return &NumberNode{
+		return p.node(start, &NumberNode{
 			value:     token.value,
 			tokenType: token.tokenType,
-		}, nil
+		}), nil
 	}
 
 	if token.tokenType == STRING {
 		p.index++
-		return &StringNode{
+		return p.node(start, &StringNode{
 			value:     token.value,
 			tokenType: token.tokenType,
-		}, nil
+		}), nil
+	}
+
+	if token.tokenType == STRING_PART {
+		parts := make([]Node, 0)
+		for p.check(STRING_PART) {
+			part := p.advance()
+			if part.value != "" {
+				parts = append(parts, p.node(p.index-1, &StringNode{value: part.value, tokenType: STRING}))
+			}
+			expression, err := p.parseAssignment()
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, expression)
+		}
+		// 最後の式の後ろには文字列の残りが続く
+		end, err := p.consume(STRING_END, "Expect '}' after interpolated expression.")
+		if err != nil {
+			return nil, err
+		}
+		if end.value != "" {
+			parts = append(parts, p.node(p.index-1, &StringNode{value: end.value, tokenType: STRING}))
+		}
+		return p.node(start, &Interpolation{
+			parts:     parts,
+			tokenType: STRING,
+			line:      token.line,
+		}), nil
 	}
 
 	if token.tokenType == LEFT_PAREN {
 		var expression Node
 		p.index++
-		expression, _ = p.parseAssignment()
-		if p.tokens[p.index].tokenType == "RIGHT_PAREN" {
-			p.index++
-			return &Group{
-				nodes:     []Node{expression},
-				tokenType: token.tokenType,
-			}, nil
+		expression, err := p.parseAssignment()
+		if err != nil {
+			return nil, err
 		}
-		return expression, fmt.Errorf("missing right parenthesis")
+		if _, err := p.consume(RIGHT_PAREN, "Expect ')' after expression."); err != nil {
+			return nil, err
+		}
+		return p.node(start, &Group{
+			nodes:     []Node{expression},
+			tokenType: token.tokenType,
+		}), nil
 	}
 
 	if token.tokenType == IDENTIFIER {
 		p.index++
-		return &IdentifierNode{
+		return p.node(start, &IdentifierNode{
 			value:     token.value,
 			tokenType: token.tokenType,
-		}, nil
+			line:      token.line,
+		}), nil
 	}
 
-	return &NilNode{}, fmt.Errorf("unknown expression")
+	return nil, p.errorAt(token, "Expect expression.")
 }
-
-
@@ -0,0 +1,71 @@
+package run
+
+import "slices"
+
+// Policy は実行するスクリプトに与える権限と制限。
+// Interpreter ごとに渡すので、同じプロセスで複数のポリシーを使い分けられる
+type Policy struct {
+	Limits
+	// 使える組み込み関数の名前。nil なら全ての組み込み関数を使える
+	AllowedNatives []string
+	// ファイルの読み書きを許可するか
+	AllowFS bool
+	// プロセスの操作 (終了コードの指定など) を許可するか
+	AllowProcess bool
+	// 一つの文字列が持てる最大バイト数。0 は無制限
+	MaxStringBytes int
+	// 一つのリストが持てる最大の要素数。0 は無制限
+	MaxListElements int
+}
+
+// DefaultPolicy は手元でスクリプトを実行するときのポリシー
+func DefaultPolicy() Policy {
+	return Policy{
+		Limits:       DefaultLimits(),
+		AllowProcess: true,
+	}
+}
+
+// SandboxPolicy はユーザーが書いたスクリプトを実行するときのポリシー。
+// 副作用のない組み込み関数だけを使え、実行量とメモリに上限がある
+func SandboxPolicy() Policy {
+	return Policy{
+		Limits: Limits{
+			MaxCallDepth: 1000,
+			MaxSteps:     1000000,
+		},
+		AllowedNatives: []string{
+			"clock",
+			"len", "substr", "indexOf", "split", "join", "upper", "lower", "trim", "replace", "startsWith", "chr", "ord", "str",
+			"get",
+			"sqrt", "pow", "floor", "ceil", "round", "abs", "min", "max", "sin", "cos", "log", "random", "parseNumber",
+		},
+		MaxStringBytes:  1 << 20,
+		MaxListElements: 1 << 16,
+	}
+}
+
+func (p *Policy) allowsNative(name string) bool {
+	return p.AllowedNatives == nil || slices.Contains(p.AllowedNatives, name)
+}
+
+// checkString は文字列の大きさがポリシーの上限を超えていないか確認する
+func (in *Interpreter) checkString(env *Env, line int, value string) {
+	in.checkStringSize(env, line, len(value))
+}
+
+// checkStringSize は size バイトの文字列を作ってよいか確認する。
+// 大きくなりうる文字列は、メモリを確保する前にこれで長さを確認する
+func (in *Interpreter) checkStringSize(env *Env, line int, size int) {
+	if in.policy.MaxStringBytes > 0 && size > in.policy.MaxStringBytes {
+		runtimeError(env, line, "String exceeds the maximum size of %d bytes.", in.policy.MaxStringBytes)
+	}
+}
+
+// checkListSize は size 個の要素を持つリストを作ってよいか確認する。
+// 文字列と同じく、要素を確保する前に長さが分かる場合は先に確認する
+func (in *Interpreter) checkListSize(env *Env, line int, size int) {
+	if in.policy.MaxListElements > 0 && size > in.policy.MaxListElements {
+		runtimeError(env, line, "List exceeds the maximum size of %d elements.", in.policy.MaxListElements)
+	}
+}
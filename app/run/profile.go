@@ -0,0 +1,254 @@
+package run
+
+import (
+	"compress/gzip"
+	"io"
+	"time"
+)
+
+// profiler は関数の呼び出し回数と、文ごとの実行時間を記録する。
+// 時間は文の実行時間からその中で実行された文の時間を引いた分 (自身の時間) を、
+// その時点のコールスタックに積み上げる。包含時間は pprof がスタックから計算する
+type profiler struct {
+	filename string
+	start    time.Time
+	// 実行中の関数。先頭はトップレベルのスクリプト
+	frames []profileFrame
+	// 実行中の文。関数の呼び出しも一つの文として積み、組み込み関数や引数の束縛の時間を数える
+	lines []profileLine
+	// コールスタックを根から辿る木。節点一つが pprof のサンプル一つになる
+	root *profileNode
+}
+
+// profileFunction は関数を宣言された行で区別する。同じ名前のローカル関数が複数あってもよい
+type profileFunction struct {
+	name string
+	line int
+}
+
+// profileLocation は pprof の Location。関数の中で実行中の行を表す
+type profileLocation struct {
+	function profileFunction
+	line     int
+}
+
+type profileFrame struct {
+	function profileFunction
+	// 今実行している行
+	line int
+	// このフレームを呼び出した時点のコールスタック
+	caller *profileNode
+}
+
+type profileLine struct {
+	start time.Time
+	// 中で実行された文の時間の合計
+	children time.Duration
+	// 文を実行しているフレームの添字と、文を実行する前にフレームが実行していた行
+	frame    int
+	previous int
+}
+
+// profileNode は根からのコールスタック一つと、そのスタックで記録した値
+type profileNode struct {
+	location profileLocation
+	parent   *profileNode
+	children map[profileLocation]*profileNode
+	calls    int64
+	nanos    int64
+}
+
+func (n *profileNode) child(location profileLocation) *profileNode {
+	child, ok := n.children[location]
+	if !ok {
+		child = &profileNode{location: location, parent: n, children: make(map[profileLocation]*profileNode)}
+		n.children[location] = child
+	}
+	return child
+}
+
+func newProfiler(filename string) *profiler {
+	root := &profileNode{children: make(map[profileLocation]*profileNode)}
+	return &profiler{
+		filename: filename,
+		start:    time.Now(),
+		frames:   []profileFrame{{function: profileFunction{name: "script"}, caller: root}},
+		root:     root,
+	}
+}
+
+// EnableProfiling は実行中に関数ごと、行ごとの時間を記録するようにする。
+// filename は pprof に表示するスクリプトのファイル名
+func (in *Interpreter) EnableProfiling(filename string) {
+	in.profiler = newProfiler(filename)
+}
+
+// enterLine は line の文の実行を始める
+func (p *profiler) enterLine(line int) {
+	frame := &p.frames[len(p.frames)-1]
+	p.lines = append(p.lines, profileLine{start: time.Now(), frame: len(p.frames) - 1, previous: frame.line})
+	frame.line = line
+}
+
+// exitLine は実行中の文を終え、中の文を除いた時間を記録する
+func (p *profiler) exitLine() {
+	l := p.lines[len(p.lines)-1]
+	p.lines = p.lines[:len(p.lines)-1]
+	elapsed := time.Since(l.start)
+	if len(p.lines) > 0 {
+		p.lines[len(p.lines)-1].children += elapsed
+	}
+	p.sample().nanos += int64(elapsed - l.children)
+	p.frames[l.frame].line = l.previous
+}
+
+// enterFunction は関数の呼び出しを一回数え、関数の実行を始める
+func (p *profiler) enterFunction(function *Function) {
+	p.frames = append(p.frames, profileFrame{
+		function: profileFunction{name: function.name, line: function.line},
+		line:     function.line,
+		caller:   p.sample(),
+	})
+	p.sample().calls++
+	p.enterLine(function.line)
+}
+
+func (p *profiler) exitFunction() {
+	p.exitLine()
+	p.frames = p.frames[:len(p.frames)-1]
+}
+
+// sample は今のコールスタックに対応する節点を返す
+func (p *profiler) sample() *profileNode {
+	frame := p.frames[len(p.frames)-1]
+	return frame.caller.child(profileLocation{function: frame.function, line: frame.line})
+}
+
+// WriteProfile は記録したプロファイルを pprof の形式 (gzip で圧縮した protocol buffers) で w に書き出す。
+// サンプルの値は呼び出し回数と時間 (ナノ秒) で、`go tool pprof` で表示できる
+func (in *Interpreter) WriteProfile(w io.Writer) error {
+	p := in.profiler
+	// 文字列表の先頭は空文字列でなければならない
+	table := []string{""}
+	index := map[string]int64{"": 0}
+	str := func(s string) int64 {
+		if i, ok := index[s]; ok {
+			return i
+		}
+		table = append(table, s)
+		index[s] = int64(len(table) - 1)
+		return index[s]
+	}
+
+	var profile []byte
+	valueType := func(kind, unit string) []byte {
+		var b []byte
+		b = protoInt(b, 1, str(kind))
+		return protoInt(b, 2, str(unit))
+	}
+	profile = protoBytes(profile, 1, valueType("calls", "count"))
+	profile = protoBytes(profile, 1, valueType("time", "nanoseconds"))
+
+	// 同じ関数と行には同じ Location を使う
+	locations := make(map[profileLocation]uint64)
+	var order []profileLocation
+	var walk func(n *profileNode)
+	walk = func(n *profileNode) {
+		if n.calls != 0 || n.nanos != 0 {
+			// Location は呼び出された側から順に並べる
+			var ids []uint64
+			for node := n; node != p.root; node = node.parent {
+				id, ok := locations[node.location]
+				if !ok {
+					order = append(order, node.location)
+					id = uint64(len(order))
+					locations[node.location] = id
+				}
+				ids = append(ids, id)
+			}
+			var b []byte
+			b = protoPacked(b, 1, ids)
+			b = protoPacked(b, 2, []uint64{uint64(n.calls), uint64(n.nanos)})
+			profile = protoBytes(profile, 2, b)
+		}
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+	walk(p.root)
+
+	functions := make(map[profileFunction]uint64)
+	var functionBytes [][]byte
+	for i, location := range order {
+		function, ok := functions[location.function]
+		if !ok {
+			function = uint64(len(functions) + 1)
+			functions[location.function] = function
+			var b []byte
+			b = protoInt(b, 1, int64(function))
+			b = protoInt(b, 2, str(location.function.name))
+			b = protoInt(b, 3, str(location.function.name))
+			b = protoInt(b, 4, str(p.filename))
+			b = protoInt(b, 5, int64(location.function.line))
+			functionBytes = append(functionBytes, b)
+		}
+		var line []byte
+		line = protoInt(line, 1, int64(function))
+		line = protoInt(line, 2, int64(location.line))
+		var b []byte
+		b = protoInt(b, 1, int64(i+1))
+		b = protoBytes(b, 4, line)
+		profile = protoBytes(profile, 4, b)
+	}
+	for _, b := range functionBytes {
+		profile = protoBytes(profile, 5, b)
+	}
+
+	profile = protoInt(profile, 9, p.start.UnixNano())
+	profile = protoInt(profile, 10, int64(time.Since(p.start)))
+	profile = protoBytes(profile, 11, valueType("time", "nanoseconds"))
+	profile = protoInt(profile, 12, 1)
+	profile = protoInt(profile, 14, str("time"))
+	// 文字列表は他のフィールドで使う文字列が出揃ってから書く
+	for _, s := range table {
+		profile = protoBytes(profile, 6, []byte(s))
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(profile); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// protocol buffers の書き出し。pprof の形式に必要な varint と長さ付きのフィールドだけを扱う
+
+func protoVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func protoInt(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protoVarint(b, uint64(field)<<3)
+	return protoVarint(b, uint64(v))
+}
+
+func protoBytes(b []byte, field int, v []byte) []byte {
+	b = protoVarint(b, uint64(field)<<3|2)
+	b = protoVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func protoPacked(b []byte, field int, values []uint64) []byte {
+	var packed []byte
+	for _, v := range values {
+		packed = protoVarint(packed, v)
+	}
+	return protoBytes(b, field, packed)
+}
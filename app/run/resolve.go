@@ -0,0 +1,199 @@
+package run
+
+// resolve は実行前にローカル変数の場所を決める。
+// 実行時に作られる環境と同じ入れ子のスコープを辿り、変数の参照ごとに
+// 何個外側の環境の何番目の slot にあるかを binding に書き込む。
+// どのスコープにも見つからない名前はグローバル変数として名前で探す
+func resolve(statements []Statement) {
+	r := &resolver{}
+	r.statements(statements)
+}
+
+// resolverScope は実行時の環境一つに対応する
+type resolverScope struct {
+	slots map[string]int
+	// 関数として宣言された名前
+	functions map[string]bool
+	// スコープの終わりで解決する関数の本体
+	deferred []func()
+}
+
+type resolver struct {
+	scopes []*resolverScope
+	// 関数の本体を解決している間、外側のスコープで見える slot の数。
+	// visible[i] より後ろの slot は関数の宣言より後で宣言された変数なので見えない
+	visible []int
+}
+
+func (r *resolver) pushScope() {
+	r.scopes = append(r.scopes, &resolverScope{slots: make(map[string]int), functions: make(map[string]bool)})
+}
+
+// popScope はスコープを閉じる。
+// 関数の本体はスコープの宣言が出揃ったここで解決する。
+// こうすると関数の後ろで宣言されたローカル関数 (相互再帰など) も参照できる
+func (r *resolver) popScope() {
+	scope := r.scopes[len(r.scopes)-1]
+	for len(scope.deferred) > 0 {
+		body := scope.deferred[0]
+		scope.deferred = scope.deferred[1:]
+		body()
+	}
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+// snapshot は今の位置で見えるスコープごとの slot の数を返す
+func (r *resolver) snapshot() []int {
+	visible := make([]int, len(r.scopes))
+	for i, scope := range r.scopes {
+		visible[i] = len(scope.slots)
+		if i < len(r.visible) {
+			visible[i] = min(visible[i], r.visible[i])
+		}
+	}
+	return visible
+}
+
+// declare は今のスコープに name を宣言する。同じスコープでの再宣言は同じ slot を使う
+func (r *resolver) declare(name string) binding {
+	if len(r.scopes) == 0 {
+		return binding{}
+	}
+	scope := r.scopes[len(r.scopes)-1]
+	slot, ok := scope.slots[name]
+	if !ok {
+		slot = len(scope.slots)
+		scope.slots[name] = slot
+	}
+	return binding{local: true, depth: 0, slot: slot}
+}
+
+// lookup は name を内側のスコープから順に探す
+func (r *resolver) lookup(name string) binding {
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		slot, ok := r.scopes[i].slots[name]
+		if !ok {
+			continue
+		}
+		// 関数より後で宣言された変数は見えないが、関数は見える
+		if i < len(r.visible) && slot >= r.visible[i] && !r.scopes[i].functions[name] {
+			continue
+		}
+		return binding{local: true, depth: len(r.scopes) - 1 - i, slot: slot}
+	}
+	return binding{}
+}
+
+func (r *resolver) statements(statements []Statement) {
+	for _, statement := range statements {
+		r.statement(statement)
+	}
+}
+
+// block は新しい環境で実行される文を解決する
+func (r *resolver) block(statements []Statement) {
+	r.pushScope()
+	r.statements(statements)
+	r.popScope()
+}
+
+func (r *resolver) statement(statement Statement) {
+	switch s := statement.(type) {
+	case *PrintStatement:
+		r.expression(s.expr)
+	case *ExpressionStatement:
+		r.expression(s.expr)
+	case *ReturnStatement:
+		r.expression(s.expr)
+	case *VariableStatement:
+		// var a = a; の右辺は外側の a を指す
+		r.expression(s.expr)
+		s.binding = r.declare(s.varName)
+	case *BlockStatement:
+		r.block(s.statements)
+	case *IfStatement:
+		// 条件は if の外側の環境で、本体は新しい環境で評価される
+		r.expression(s.expr)
+		r.block(s.statements)
+		for _, elseIf := range s.elseIfStatements {
+			r.expression(elseIf.expr)
+			r.block(elseIf.statements)
+		}
+		r.block(s.elseStatements)
+	case *WhileStatement:
+		// 条件も本体も、ループ全体で一つの環境で評価される
+		r.pushScope()
+		r.expression(s.expr)
+		r.statements(s.statements)
+		r.popScope()
+	case *ForStatement:
+		// 初期化、条件、更新は for の環境で、本体は一周ごとの新しい環境で評価される
+		r.pushScope()
+		r.statement(s.firstStatement)
+		r.expression(s.expression)
+		r.block(s.statements)
+		r.statement(s.endStatement)
+		r.popScope()
+	case *FunStatement:
+		s.binding = r.declare(s.name)
+		if len(r.scopes) > 0 {
+			r.scopes[len(r.scopes)-1].functions[s.name] = true
+		}
+		visible := r.snapshot()
+		body := func() {
+			outer := r.visible
+			r.visible = visible
+			r.pushScope()
+			for _, parameter := range s.parameters {
+				r.declare(parameter)
+			}
+			r.statements(s.statements)
+			r.popScope()
+			r.visible = outer
+		}
+		if len(r.scopes) == 0 {
+			// トップレベルの関数からはグローバル変数しか見えないので、すぐに解決してよい
+			body()
+		} else {
+			scope := r.scopes[len(r.scopes)-1]
+			scope.deferred = append(scope.deferred, body)
+		}
+	}
+}
+
+func (r *resolver) expression(expr Node) {
+	switch e := expr.(type) {
+	case *IdentifierNode:
+		e.binding = r.lookup(e.value)
+	case *AssignmentNode:
+		r.expression(e.value)
+		e.binding = r.lookup(e.varName)
+	case *UpdateNode:
+		if e.value != nil {
+			r.expression(e.value)
+		}
+		e.binding = r.lookup(e.varName)
+	case *Interpolation:
+		for _, part := range e.parts {
+			r.expression(part)
+		}
+	case *Group:
+		for _, node := range e.nodes {
+			r.expression(node)
+		}
+	case *Unary:
+		r.expression(e.right)
+	case *Binary:
+		r.expression(e.left)
+		r.expression(e.right)
+	case *Ternary:
+		r.expression(e.condition)
+		r.expression(e.thenBranch)
+		r.expression(e.elseBranch)
+	case *FuncNode:
+		r.expression(e.callee)
+		for _, argument := range e.arguments {
+			r.expression(argument)
+		}
+	}
+}
@@ -1,27 +1,133 @@
 package run
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"os"
 )
 
 func Run() {
-	filename := os.Args[2]
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	sandbox := flags.Bool("sandbox", false, "run with the sandbox policy (allow-listed natives, no file or process access)")
+	maxDepth := flags.Int("max-depth", 0, "maximum call depth (0 for unlimited)")
+	maxSteps := flags.Int("max-steps", 0, "maximum number of executed statements (0 for unlimited)")
+	timeout := flags.Duration("timeout", 0, "wall-clock time limit, e.g. 5s (0 for unlimited)")
+	seed := flags.Uint64("seed", 0, "seed for random() to make runs reproducible")
+	allowFS := flags.Bool("allow-fs", false, "allow readFile and writeFile")
+	noOpt := flags.Bool("no-opt", false, "disable constant folding and dead-branch elimination")
+	cover := flags.String("cover", "", "write LCOV line and branch coverage to `file` (disables optimization)")
+	trace := flags.Bool("trace", false, "print executed statements, assignments and calls to stderr (disables optimization)")
+	traceFunc := flags.String("trace-func", "", "trace only calls of the function `name` (implies --trace)")
+	profile := flags.String("profile", "", "write a pprof profile of Lox functions and lines to `file`")
+	flags.Parse(os.Args[2:])
+	if flags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: ./your_program.sh run [flags] <filename> [args...]")
+		os.Exit(1)
+	}
+
+	policy := DefaultPolicy()
+	if *sandbox {
+		policy = SandboxPolicy()
+	}
+	policy.AllowFS = *allowFS
+	// 明示的に指定された制限だけポリシーの値を上書きする
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "max-depth":
+			policy.MaxCallDepth = *maxDepth
+		case "max-steps":
+			policy.MaxSteps = *maxSteps
+		}
+	})
+
+	filename := flags.Arg(0)
 	fileContents, err := os.ReadFile(filename)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
 		os.Exit(1)
 	}
 
-	parser := Parser{
-		tokens: tokenize(fileContents),
-		index:  0,
+	program, ok := ParseProgram(fileContents)
+	// 構文エラーは全て報告済みなので、終了コードだけ返す
+	if !ok {
+		os.Exit(65)
 	}
+	statements := program.statements
 
-	statements := parser.parseStatements()
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
 
-	env := NewEnv()
-	for _, statement := range statements {
-		statement.Execute(env)
+	interpreter := NewInterpreter(policy)
+	interpreter.SetArgs(flags.Args()[1:])
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			interpreter.SeedRandom(*seed)
+		}
+	})
+	if *profile != "" {
+		interpreter.EnableProfiling(filename)
+	}
+	tracing := *trace || *traceFunc != ""
+	if tracing {
+		interpreter.EnableTracing(os.Stderr, program, *traceFunc)
+	}
+	coverage := NewCoverage()
+	if *cover != "" {
+		interpreter.EnableCoverage(coverage, filename, statements)
+	}
+	// 最適化で消えた分岐も数え、書かれた通りの文を表示するため、カバレッジとトレースでは最適化しない
+	if !*noOpt && *cover == "" && !tracing {
+		statements = interpreter.optimize(statements)
+	}
+	err = interpreter.Interpret(ctx, statements)
+	// エラーで止まった場合も、そこまでのプロファイルを書き出す
+	if *profile != "" {
+		if err := writeProfile(interpreter, *profile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing profile: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *cover != "" {
+		if err := writeCoverage(coverage, *cover); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing coverage: %v\n", err)
+			os.Exit(1)
+		}
+		coverage.WriteSummary(os.Stderr)
+	}
+	if err != nil {
+		if exitErr, ok := err.(*ExitError); ok {
+			os.Exit(exitErr.Code)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(70)
+	}
+}
+
+func writeProfile(interpreter *Interpreter, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := interpreter.WriteProfile(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func writeCoverage(coverage *Coverage, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := coverage.WriteLCOV(file); err != nil {
+		file.Close()
+		return err
 	}
+	return file.Close()
 }
@@ -0,0 +1,94 @@
+package run
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ParseExpression は source 全体を一つの式としてパースする。
+// 字句解析と構文のエラーは標準エラーに出力し、その場合は ok が false になる
+func ParseExpression(source []byte) (expr Node, ok bool) {
+	tokens, _, ok := tokenize(source)
+	if !ok {
+		return nil, false
+	}
+	parser := Parser{tokens: tokens}
+	expr = parser.parseWholeExpression()
+	return expr, len(parser.errors) == 0
+}
+
+// parseWholeExpression は式を一つパースし、その後ろにトークンが残っていればエラーにする
+func (p *Parser) parseWholeExpression() Node {
+	defer p.recoverNesting()
+	expr, err := p.parseAssignment()
+	if err == nil && !p.isAtEnd() {
+		err = p.errorAt(p.tokens[p.index], "Expect end of expression.")
+	}
+	if err != nil {
+		p.report(err)
+		return nil
+	}
+	return expr
+}
+
+// SExpr は式を `(+ 1.0 (group 2.0))` のような S 式にする。
+// 演算子はソースに書かれた通りに、数値はトークンのリテラルと同じ形式で出力する
+func SExpr(expr Node) string {
+	var b strings.Builder
+	writeSExpr(&b, expr)
+	return b.String()
+}
+
+func writeSExpr(b *strings.Builder, expr Node) {
+	// list は `(head 子 子 ...)` の形で出力する
+	list := func(head string, children ...Node) {
+		b.WriteString("(" + head)
+		for _, child := range children {
+			b.WriteString(" ")
+			writeSExpr(b, child)
+		}
+		b.WriteString(")")
+	}
+
+	switch e := expr.(type) {
+	case *NilNode:
+		b.WriteString("nil")
+	case *BooleanNode:
+		b.WriteString(e.value)
+	case *NumberNode:
+		value, _ := strconv.ParseFloat(e.value, 64)
+		b.WriteString(FormatLiteral(value))
+	case *StringNode:
+		b.WriteString(e.value)
+	case *IdentifierNode:
+		b.WriteString(e.value)
+	case *Interpolation:
+		list("interpolation", e.parts...)
+	case *Group:
+		list("group", e.nodes...)
+	case *Unary:
+		list(e.operator.lexeme, e.right)
+	case *Binary:
+		list(e.operator.lexeme, e.left, e.right)
+	case *Ternary:
+		list("?", e.condition, e.thenBranch, e.elseBranch)
+	case *FuncNode:
+		list("call", append([]Node{e.callee}, e.arguments...)...)
+	case *AssignmentNode:
+		b.WriteString("(= " + e.varName + " ")
+		writeSExpr(b, e.value)
+		b.WriteString(")")
+	case *UpdateNode:
+		switch {
+		case e.value != nil:
+			b.WriteString("(" + e.operator.lexeme + " " + e.varName + " ")
+			writeSExpr(b, e.value)
+			b.WriteString(")")
+		case e.prefix:
+			b.WriteString("(" + e.operator.lexeme + " " + e.varName + ")")
+		default:
+			// x++ は演算子を後ろに書いて ++x と区別する
+			b.WriteString("(" + e.varName + " " + e.operator.lexeme + ")")
+		}
+	}
+}
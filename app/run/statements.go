@@ -6,16 +6,20 @@ import (
 
 type Statement interface {
 	Execute(env *Env) *ReturnError
+	// 文が始まる行
+	getLine() int
 }
 
 type PrintStatement struct {
 	Statement
 	expr Node
+	line int
 }
 
 type BlockStatement struct {
 	Statement
 	statements []Statement
+	line       int
 }
 
 type FunStatement struct {
@@ -24,11 +28,14 @@ type FunStatement struct {
 	parameters []string
 	statements []Statement
 	closure    *Env
+	line       int
+	binding    binding
 }
 
 type ExpressionStatement struct {
 	Statement
 	expr Node
+	line int
 }
 
 // var xxx = yyy; の時に生成されるやつ
@@ -36,6 +43,8 @@ type VariableStatement struct {
 	Statement
 	expr    Node
 	varName string
+	line    int
+	binding binding
 }
 
 // if xxx { } の時に生成されるやつ
@@ -44,12 +53,14 @@ type IfStatement struct {
 	expr             Node
 	statements       []Statement
 	elseStatements   []Statement
-	elseIfStatements []IfStatement
+	elseIfStatements []*IfStatement
+	line             int
 }
 type WhileStatement struct {
 	Statement
 	expr       Node
 	statements []Statement
+	line       int
 }
 
 type ForStatement struct {
@@ -59,17 +70,20 @@ type ForStatement struct {
 	endStatement   Statement
 	// for の中の文
 	statements []Statement
+	line       int
 }
 
 type ReturnStatement struct {
 	Statement
 	expr Node
+	line int
 }
 
 type ReturnError struct {
 	value     string
 	valueType string
 	function  *Function
+	list      []EvaluateNode
 }
 
 func (e *ExpressionStatement) Execute(env *Env) *ReturnError {
@@ -80,7 +94,7 @@ func (e *ExpressionStatement) Execute(env *Env) *ReturnError {
 func (b *BlockStatement) Execute(env *Env) *ReturnError {
 	newEnv := env.NewChildEnv()
 	for _, statement := range b.statements {
-		if err := statement.Execute(newEnv); err != nil {
+		if err := execute(statement, newEnv); err != nil {
 			return err
 		}
 	}
@@ -90,8 +104,7 @@ func (b *BlockStatement) Execute(env *Env) *ReturnError {
 }
 
 func (p *PrintStatement) Execute(env *Env) *ReturnError {
-	value := p.expr.getValue(env).value
-	fmt.Println(value)
+	fmt.Fprintln(env.interpreter.out, stringify(p.expr.getValue(env)))
 
 	return nil
 }
@@ -99,53 +112,61 @@ func (p *PrintStatement) Execute(env *Env) *ReturnError {
 func (f *ForStatement) Execute(parentEnv *Env) *ReturnError {
 	newEnv := parentEnv.NewChildEnv()
 
-	if err := f.firstStatement.Execute(newEnv); err != nil {
+	if err := execute(f.firstStatement, newEnv); err != nil {
 		return err
 	}
 
+	iterations := 0
 	for isTrueString(f.expression.getValue(newEnv).value) {
+		// 本体が空のループでも制限が効くように、一周ごとに数える
+		newEnv.interpreter.tick(newEnv, f.line)
+		newEnv.interpreter.coverBranch(f, 0)
+		iterations++
 		grandChildEnv := newEnv.NewChildEnv()
 		for _, statement := range f.statements {
-			if err := statement.Execute(grandChildEnv); err != nil {
+			if err := execute(statement, grandChildEnv); err != nil {
 				return err
 			}
 		}
-		if err := f.endStatement.Execute(newEnv); err != nil {
+		if err := execute(f.endStatement, newEnv); err != nil {
 			return err
 		}
 	}
+	if iterations == 0 {
+		newEnv.interpreter.coverBranch(f, 1)
+	}
 	return nil
 }
 
 func (v *VariableStatement) Execute(env *Env) *ReturnError {
 	value := v.expr.getValue(env)
 	// 新しい変数を現在の環境に定義
-	env.Define(v.varName, value)
+	env.declare(v.binding, v.varName, value)
+	if tracer := env.interpreter.tracer; tracer != nil {
+		tracer.assign(v.varName, value)
+	}
 	return nil
 }
 
 func (i *IfStatement) Execute(parentEnv *Env) *ReturnError {
 	value := i.expr.getValue(parentEnv)
 	newEnv := parentEnv.NewChildEnv()
-	statements := []Statement{}
-	if isTrueString(value.value) {
-		statements = i.statements
-	} else if len(i.elseIfStatements) > 0 {
-		for _, elseIfStatement := range i.elseIfStatements {
-			// 何も条件に引っ掛からなかった場合は else を実行する
-			statements = i.elseStatements
-
+	// arm は選ばれた分岐の番号。then, else if..., else の順に数える
+	statements, arm := i.statements, 0
+	if !isTrueString(value.value) {
+		// 何も条件に引っ掛からなかった場合は else を実行する
+		statements, arm = i.elseStatements, len(i.elseIfStatements)+1
+		for index, elseIfStatement := range i.elseIfStatements {
 			if isTrueString(elseIfStatement.expr.getValue(parentEnv).value) {
-				statements = elseIfStatement.statements
+				statements, arm = elseIfStatement.statements, index+1
 				break
 			}
 		}
-	} else {
-		statements = i.elseStatements
 	}
+	parentEnv.interpreter.coverBranch(i, arm)
 
 	for _, statement := range statements {
-		if err := statement.Execute(newEnv); err != nil {
+		if err := execute(statement, newEnv); err != nil {
 			return err
 		}
 	}
@@ -154,20 +175,27 @@ func (i *IfStatement) Execute(parentEnv *Env) *ReturnError {
 
 func (w *WhileStatement) Execute(parentEnv *Env) *ReturnError {
 	newEnv := parentEnv.NewChildEnv()
+	iterations := 0
 	for isTrueString(w.expr.getValue(newEnv).value) {
+		// 本体が空のループでも制限が効くように、一周ごとに数える
+		newEnv.interpreter.tick(newEnv, w.line)
+		newEnv.interpreter.coverBranch(w, 0)
+		iterations++
 		if len(w.statements) > 0 {
 			for _, statement := range w.statements {
-				if err := statement.Execute(newEnv); err != nil {
+				if err := execute(statement, newEnv); err != nil {
 					return err
 				}
 			}
 		}
 		// ループ内での変更を親環境に反映
 	}
+	if iterations == 0 {
+		newEnv.interpreter.coverBranch(w, 1)
+	}
 	return nil
 }
 
-
 func (f *FunStatement) Execute(env *Env) *ReturnError {
 	// 関数を定義
 	fn := Function{
@@ -175,10 +203,11 @@ func (f *FunStatement) Execute(env *Env) *ReturnError {
 		parameters: f.parameters,
 		statements: f.statements,
 		closure:    env, // 現在の環境をクロージャとして保持
+		line:       f.line,
 	}
 
 	// 関数を変数として定義
-	env.Define(f.name, EvaluateNode{
+	env.declare(f.binding, f.name, EvaluateNode{
 		value:     "<fn " + f.name + ">",
 		valueType: "function",
 		function:  &fn,
@@ -201,10 +230,46 @@ func (r *ReturnStatement) Execute(env *Env) *ReturnError {
 	return &ReturnError{
 		value:     node.value,
 		valueType: node.valueType,
+		list:      node.list,
 	}
 }
 
-
 func (r *ReturnError) Error() string {
 	return r.valueType + " " + r.value
 }
+
+func (p *PrintStatement) getLine() int {
+	return p.line
+}
+
+func (b *BlockStatement) getLine() int {
+	return b.line
+}
+
+func (f *FunStatement) getLine() int {
+	return f.line
+}
+
+func (e *ExpressionStatement) getLine() int {
+	return e.line
+}
+
+func (v *VariableStatement) getLine() int {
+	return v.line
+}
+
+func (i *IfStatement) getLine() int {
+	return i.line
+}
+
+func (w *WhileStatement) getLine() int {
+	return w.line
+}
+
+func (f *ForStatement) getLine() int {
+	return f.line
+}
+
+func (r *ReturnStatement) getLine() int {
+	return r.line
+}
@@ -0,0 +1,29 @@
+package run
+
+import "strconv"
+
+// stringify は値を print で表示する形式の文字列にする。
+// print、文字列補間、str() は全てこの形式を使う
+func stringify(node EvaluateNode) string {
+	switch node.valueType {
+	case NUMBER:
+		// 数値はどこで計算されても同じ表記になるように書き直す
+		num, err := strconv.ParseFloat(node.value, 64)
+		if err != nil {
+			return node.value
+		}
+		return FormatNumber(num)
+	case NIL:
+		return "nil"
+	case LIST:
+		return stringifyList(node.list)
+	case "function":
+		if node.function != nil && node.function.native != nil {
+			return "<native fn>"
+		}
+		if node.function != nil {
+			return "<fn " + node.function.name + ">"
+		}
+	}
+	return node.value
+}
@@ -0,0 +1,187 @@
+package run
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 文字列を扱う組み込み関数。位置や長さは全てバイトではなく文字 (rune) 単位
+
+// len(x) は文字列の文字数か、リストの要素数を返す
+func nativeLen(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	if args[0].valueType == LIST {
+		return numberValue(float64(len(args[0].list)))
+	}
+	if args[0].valueType != STRING {
+		runtimeError(env, line, "Argument 1 of 'len' must be a string or a list.")
+	}
+	return numberValue(float64(utf8.RuneCountInString(args[0].value)))
+}
+
+// substr(s, start, end) は start 文字目から end 文字目の手前までを返す
+func nativeSubstr(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	runes := []rune(stringArg(env, line, "substr", args, 0))
+	start := integerArg(env, line, "substr", args, 1)
+	end := integerArg(env, line, "substr", args, 2)
+	if start < 0 || end > len(runes) || start > end {
+		runtimeError(env, line, "Substring range [%d, %d) is out of bounds for length %d.", start, end, len(runes))
+	}
+	return stringValue(env, line, string(runes[start:end]))
+}
+
+// indexOf(s, sub) は sub が最初に現れる位置を返す。見つからなければ -1
+func nativeIndexOf(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	s := stringArg(env, line, "indexOf", args, 0)
+	sub := stringArg(env, line, "indexOf", args, 1)
+	index := strings.Index(s, sub)
+	if index < 0 {
+		return numberValue(-1)
+	}
+	return numberValue(float64(utf8.RuneCountInString(s[:index])))
+}
+
+// split(s, sep) は s を sep で区切った文字列のリストを返す。sep が空なら一文字ずつに分ける
+func nativeSplit(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	s := stringArg(env, line, "split", args, 0)
+	sep := stringArg(env, line, "split", args, 1)
+	// 区切った結果を確保する前に要素の数を確認する
+	count := strings.Count(s, sep) + 1
+	if sep == "" {
+		count = utf8.RuneCountInString(s)
+	}
+	env.interpreter.checkListSize(env, line, count)
+	parts := strings.Split(s, sep)
+	items := make([]EvaluateNode, 0, len(parts))
+	for _, part := range parts {
+		items = append(items, EvaluateNode{value: part, valueType: STRING})
+	}
+	return listValue(env, line, items)
+}
+
+// join(list, sep) は文字列のリストを sep で繋げる
+func nativeJoin(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	list := listArg(env, line, "join", args, 0)
+	sep := stringArg(env, line, "join", args, 1)
+	parts := make([]string, 0, len(list))
+	size := 0
+	for i, item := range list {
+		if item.valueType != STRING {
+			runtimeError(env, line, "Element %d of the list passed to 'join' must be a string.", i)
+		}
+		parts = append(parts, item.value)
+		size += len(item.value)
+	}
+	if len(list) > 1 {
+		size += (len(list) - 1) * len(sep)
+	}
+	// 繋げた結果の長さを確保する前に確認する
+	env.interpreter.checkStringSize(env, line, size)
+	return stringValue(env, line, strings.Join(parts, sep))
+}
+
+// upper と lower は文字によってはバイト数が変わるので、変換後の長さを先に確認する
+func nativeUpper(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	s := stringArg(env, line, "upper", args, 0)
+	env.interpreter.checkStringSize(env, line, mappedSize(s, unicode.ToUpper))
+	return stringValue(env, line, strings.ToUpper(s))
+}
+
+func nativeLower(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	s := stringArg(env, line, "lower", args, 0)
+	env.interpreter.checkStringSize(env, line, mappedSize(s, unicode.ToLower))
+	return stringValue(env, line, strings.ToLower(s))
+}
+
+// mappedSize は s の各文字を mapping で変換した文字列のバイト数を返す
+func mappedSize(s string, mapping func(rune) rune) int {
+	size := 0
+	for _, r := range s {
+		size += utf8.RuneLen(mapping(r))
+	}
+	return size
+}
+
+func nativeTrim(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	return stringValue(env, line, strings.TrimSpace(stringArg(env, line, "trim", args, 0)))
+}
+
+// replace(s, old, new) は old を全て new に置き換える
+func nativeReplace(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	s := stringArg(env, line, "replace", args, 0)
+	old := stringArg(env, line, "replace", args, 1)
+	replacement := stringArg(env, line, "replace", args, 2)
+	// 置き換えた結果の長さを確保する前に確認する。old が空なら全ての文字の間と両端に挿入される
+	env.interpreter.checkStringSize(env, line, len(s)+strings.Count(s, old)*(len(replacement)-len(old)))
+	return stringValue(env, line, strings.ReplaceAll(s, old, replacement))
+}
+
+func nativeStartsWith(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	s := stringArg(env, line, "startsWith", args, 0)
+	prefix := stringArg(env, line, "startsWith", args, 1)
+	return booleanValue(strings.HasPrefix(s, prefix))
+}
+
+// chr(code) はコードポイントから一文字の文字列を作る
+func nativeChr(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	code := integerArg(env, line, "chr", args, 0)
+	if code < 0 || !utf8.ValidRune(rune(code)) {
+		runtimeError(env, line, "Invalid code point %d.", code)
+	}
+	return stringValue(env, line, string(rune(code)))
+}
+
+// ord(s) は一文字の文字列のコードポイントを返す
+func nativeOrd(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	s := stringArg(env, line, "ord", args, 0)
+	if utf8.RuneCountInString(s) != 1 {
+		runtimeError(env, line, "Argument to 'ord' must be a single character.")
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+	return numberValue(float64(r))
+}
+
+// str(x) は値を print と同じ形式の文字列にする
+func nativeStr(env *Env, line int, args []EvaluateNode) EvaluateNode {
+	return stringValue(env, line, stringify(args[0]))
+}
+
+// stringArg は index 番目の引数が文字列であることを確認して返す
+func stringArg(env *Env, line int, name string, args []EvaluateNode, index int) string {
+	if args[index].valueType != STRING {
+		runtimeError(env, line, "Argument %d of '%s' must be a string.", index+1, name)
+	}
+	return args[index].value
+}
+
+// integerArg は index 番目の引数が整数であることを確認して返す
+func integerArg(env *Env, line int, name string, args []EvaluateNode, index int) int {
+	num, ok := toInteger(args[index])
+	if !ok {
+		runtimeError(env, line, "Argument %d of '%s' must be an integer.", index+1, name)
+	}
+	return int(num)
+}
+
+func stringValue(env *Env, line int, value string) EvaluateNode {
+	env.interpreter.checkString(env, line, value)
+	return EvaluateNode{
+		value:     value,
+		valueType: STRING,
+	}
+}
+
+func numberValue(num float64) EvaluateNode {
+	return EvaluateNode{
+		value:     FormatNumber(num),
+		valueType: NUMBER,
+	}
+}
+
+func booleanValue(b bool) EvaluateNode {
+	return EvaluateNode{
+		value:     strconv.FormatBool(b),
+		valueType: BOOLEAN,
+	}
+}
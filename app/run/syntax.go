@@ -0,0 +1,283 @@
+package run
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// span はノードのソース上の範囲。start と end はバイト位置で、end は範囲の直後を指す
+type span struct {
+	start int
+	end   int
+}
+
+// Program はパースしたプログラムと、ノードのソース上の範囲を持つ。
+// 外部のツールに構文木を渡すために使う
+type Program struct {
+	source     []byte
+	tokens     []Token
+	comments   []Token
+	statements []Statement
+	spans      map[any]span
+	// 各行の先頭のバイト位置
+	lineStarts []int
+}
+
+// ParseProgram は source をパースする。
+// 字句解析と構文のエラーは標準エラーに出力し、その場合は ok が false になる。
+// 字句解析でエラーがあればパースはしない
+func ParseProgram(source []byte) (program *Program, ok bool) {
+	lineStarts := []int{0}
+	for i, c := range source {
+		if c == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	program = &Program{
+		source:     source,
+		spans:      make(map[any]span),
+		lineStarts: lineStarts,
+	}
+
+	program.tokens, program.comments, ok = tokenize(source)
+	if !ok {
+		return program, false
+	}
+	parser := Parser{
+		tokens: program.tokens,
+		index:  0,
+		spans:  program.spans,
+	}
+	program.statements = parser.parseStatements()
+	return program, len(parser.errors) == 0
+}
+
+// Statements はトップレベルの文を返す
+func (p *Program) Statements() []Statement {
+	return p.statements
+}
+
+// Position はソース上の位置。line と column は 1 から数え、column は文字単位
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Offset int `json:"offset"`
+}
+
+// Span は構文木のノードのソース上の範囲。End は範囲の直後の位置
+type Span struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// SyntaxNode は外部に出力するための構文木のノード。
+// 文と式を同じ形で表し、Role で親から見た役割 (condition, body など) を表す
+type SyntaxNode struct {
+	Kind     string        `json:"kind"`
+	Role     string        `json:"role,omitempty"`
+	Name     string        `json:"name,omitempty"`
+	Operator string        `json:"operator,omitempty"`
+	Value    any           `json:"value,omitempty"`
+	Prefix   bool          `json:"prefix,omitempty"`
+	Params   []string      `json:"params,omitempty"`
+	Span     *Span         `json:"span,omitempty"`
+	Children []*SyntaxNode `json:"children,omitempty"`
+}
+
+// WriteJSON は構文木を JSON で w に書き出す
+func (p *Program) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p.SyntaxTree())
+}
+
+// SyntaxTree はプログラム全体を Program ノードを根とする木に変換する
+func (p *Program) SyntaxTree() *SyntaxNode {
+	root := &SyntaxNode{Kind: "Program"}
+	if len(p.source) > 0 {
+		root.Span = &Span{Start: p.position(0), End: p.position(len(p.source))}
+	}
+	for _, statement := range p.statements {
+		root.Children = append(root.Children, p.statementNode(statement, ""))
+	}
+	return root
+}
+
+// position はバイト位置を行と列に変換する
+func (p *Program) position(offset int) Position {
+	line := sort.Search(len(p.lineStarts), func(i int) bool { return p.lineStarts[i] > offset }) - 1
+	return Position{
+		Line:   line + 1,
+		Column: utf8.RuneCount(p.source[p.lineStarts[line]:offset]) + 1,
+		Offset: offset,
+	}
+}
+
+// newNode は node の範囲を付けた SyntaxNode を作る
+func (p *Program) newNode(node any, kind string, role string) *SyntaxNode {
+	syntaxNode := &SyntaxNode{Kind: kind, Role: role}
+	if s, ok := p.spans[node]; ok {
+		syntaxNode.Span = &Span{Start: p.position(s.start), End: p.position(s.end)}
+	}
+	return syntaxNode
+}
+
+// written はノードがソースに書かれていたかどうかを返す。
+// for の省略された節や var の省略された初期値のように、パーサーが補ったノードは false になる
+func (p *Program) written(node any) bool {
+	_, ok := p.spans[node]
+	return ok
+}
+
+func (p *Program) statementNodes(parent *SyntaxNode, statements []Statement, role string) {
+	for _, statement := range statements {
+		parent.Children = append(parent.Children, p.statementNode(statement, role))
+	}
+}
+
+func (p *Program) statementNode(statement Statement, role string) *SyntaxNode {
+	switch s := statement.(type) {
+	case *PrintStatement:
+		node := p.newNode(s, "Print", role)
+		node.Children = []*SyntaxNode{p.expressionNode(s.expr, "expression")}
+		return node
+	case *ExpressionStatement:
+		node := p.newNode(s, "Expression", role)
+		node.Children = []*SyntaxNode{p.expressionNode(s.expr, "expression")}
+		return node
+	case *VariableStatement:
+		node := p.newNode(s, "Variable", role)
+		node.Name = s.varName
+		if p.written(s.expr) {
+			node.Children = []*SyntaxNode{p.expressionNode(s.expr, "initializer")}
+		}
+		return node
+	case *BlockStatement:
+		node := p.newNode(s, "Block", role)
+		p.statementNodes(node, s.statements, "")
+		return node
+	case *IfStatement:
+		node := p.newNode(s, "If", role)
+		node.Children = []*SyntaxNode{p.expressionNode(s.expr, "condition")}
+		p.statementNodes(node, s.statements, "then")
+		for _, elseIf := range s.elseIfStatements {
+			node.Children = append(node.Children, p.statementNode(elseIf, "elseIf"))
+		}
+		p.statementNodes(node, s.elseStatements, "else")
+		return node
+	case *WhileStatement:
+		node := p.newNode(s, "While", role)
+		node.Children = []*SyntaxNode{p.expressionNode(s.expr, "condition")}
+		p.statementNodes(node, s.statements, "body")
+		return node
+	case *ForStatement:
+		node := p.newNode(s, "For", role)
+		if p.written(s.firstStatement) {
+			node.Children = append(node.Children, p.statementNode(s.firstStatement, "initializer"))
+		}
+		if p.written(s.expression) {
+			node.Children = append(node.Children, p.expressionNode(s.expression, "condition"))
+		}
+		if p.written(s.endStatement) {
+			node.Children = append(node.Children, p.statementNode(s.endStatement, "increment"))
+		}
+		p.statementNodes(node, s.statements, "body")
+		return node
+	case *FunStatement:
+		node := p.newNode(s, "Function", role)
+		node.Name = s.name
+		node.Params = s.parameters
+		p.statementNodes(node, s.statements, "body")
+		return node
+	case *ReturnStatement:
+		node := p.newNode(s, "Return", role)
+		if p.written(s.expr) {
+			node.Children = []*SyntaxNode{p.expressionNode(s.expr, "value")}
+		}
+		return node
+	}
+	return p.newNode(statement, "Unknown", role)
+}
+
+func (p *Program) expressionNode(expr Node, role string) *SyntaxNode {
+	switch e := expr.(type) {
+	case *NilNode:
+		return p.newNode(e, "Nil", role)
+	case *BooleanNode:
+		node := p.newNode(e, "Boolean", role)
+		node.Value = e.value == "true"
+		return node
+	case *NumberNode:
+		node := p.newNode(e, "Number", role)
+		// JSON では Infinity を表せないので、その場合だけ文字列のままにする
+		node.Value = e.value
+		if value, err := strconv.ParseFloat(e.value, 64); err == nil && !math.IsInf(value, 0) {
+			node.Value = value
+		}
+		return node
+	case *StringNode:
+		node := p.newNode(e, "String", role)
+		node.Value = e.value
+		return node
+	case *Interpolation:
+		node := p.newNode(e, "Interpolation", role)
+		for _, part := range e.parts {
+			node.Children = append(node.Children, p.expressionNode(part, "part"))
+		}
+		return node
+	case *Group:
+		node := p.newNode(e, "Group", role)
+		for _, inner := range e.nodes {
+			node.Children = append(node.Children, p.expressionNode(inner, "expression"))
+		}
+		return node
+	case *Unary:
+		node := p.newNode(e, "Unary", role)
+		node.Operator = e.operator.lexeme
+		node.Children = []*SyntaxNode{p.expressionNode(e.right, "operand")}
+		return node
+	case *Binary:
+		node := p.newNode(e, "Binary", role)
+		node.Operator = e.operator.lexeme
+		node.Children = []*SyntaxNode{p.expressionNode(e.left, "left"), p.expressionNode(e.right, "right")}
+		return node
+	case *Ternary:
+		node := p.newNode(e, "Ternary", role)
+		node.Children = []*SyntaxNode{
+			p.expressionNode(e.condition, "condition"),
+			p.expressionNode(e.thenBranch, "then"),
+			p.expressionNode(e.elseBranch, "else"),
+		}
+		return node
+	case *IdentifierNode:
+		node := p.newNode(e, "Identifier", role)
+		node.Name = e.value
+		return node
+	case *FuncNode:
+		node := p.newNode(e, "Call", role)
+		node.Children = []*SyntaxNode{p.expressionNode(e.callee, "callee")}
+		for _, argument := range e.arguments {
+			node.Children = append(node.Children, p.expressionNode(argument, "argument"))
+		}
+		return node
+	case *AssignmentNode:
+		node := p.newNode(e, "Assignment", role)
+		node.Name = e.varName
+		node.Children = []*SyntaxNode{p.expressionNode(e.value, "value")}
+		return node
+	case *UpdateNode:
+		node := p.newNode(e, "Update", role)
+		node.Name = e.varName
+		node.Operator = e.operator.lexeme
+		node.Prefix = e.prefix
+		if e.value != nil {
+			node.Children = []*SyntaxNode{p.expressionNode(e.value, "value")}
+		}
+		return node
+	}
+	return p.newNode(expr, "Unknown", role)
+}
@@ -4,123 +4,290 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
+// LexError は字句解析のエラー。Line はエラーを報告する行、Offset はエラーの起きたバイト位置
+type LexError struct {
+	Message string
+	Line    int
+	Offset  int
+}
 
 // tokenize は、ファイルの内容をトークンに変換します。
-// これは、トークンのリストを返します。
-func tokenize(fileContents []byte) []Token {
-	errCount := 0
+// これは、トークンのリストと、フォーマッタのために `//` のコメントのリストを返します。
+// エラーがあれば全て標準エラーに出力して、ok に false を返します。
+// 終了コードは呼び出し側が決めるので、test のように続けて他のファイルを読むこともできます。
+func tokenize(fileContents []byte) (tokens []Token, comments []Token, ok bool) {
+	tokens, comments, errors := scan(fileContents)
+	for _, err := range errors {
+		fmt.Fprintf(os.Stderr, "[line %d] Error: %s\n", err.Line, err.Message)
+	}
+	return tokens, comments, len(errors) == 0
+}
+
+// LexToken は外部のツールに渡すためのトークン。Offset はソース上の先頭のバイト位置。
+// Literal は文字列のトークンならエスケープを解釈した値、数値なら正規化した表記で、それ以外は空
+type LexToken struct {
+	Type    string
+	Lexeme  string
+	Literal string
+	Offset  int
+}
+
+// Tokenize は source を run が使うのと同じ字句解析器でトークンに分ける。
+// コメントは含まない。エラーがあっても最後まで読み、EOF まで全てのトークンとエラーを返す
+func Tokenize(source []byte) ([]LexToken, []LexError) {
+	tokens, _, errors := scan(source)
+	lexTokens := make([]LexToken, 0, len(tokens))
+	for _, token := range tokens {
+		lexToken := LexToken{Type: token.tokenType, Lexeme: token.lexeme, Offset: token.offset}
+		switch token.tokenType {
+		case STRING, STRING_PART, STRING_END, NUMBER:
+			lexToken.Literal = token.value
+		}
+		lexTokens = append(lexTokens, lexToken)
+	}
+	return lexTokens, errors
+}
+
+// scan はファイルの内容をトークンとコメントに分ける。
+// エラーがあっても最後まで読み進め、見つけたエラーを全て返す
+func scan(fileContents []byte) ([]Token, []Token, []LexError) {
+	errors := make([]LexError, 0)
 	lineCount := 1
+	addError := func(offset int, format string, args ...any) {
+		errors = append(errors, LexError{Message: fmt.Sprintf(format, args...), Line: lineCount, Offset: offset})
+	}
 	tokens := make([]Token, 0)
+	comments := make([]Token, 0)
+	// 今読んでいるトークンの先頭のバイト位置
+	tokenStart := 0
+	// value と lexeme が同じトークンを追加する
+	addToken := func(tokenType string, lexeme string) {
+		tokens = append(tokens, Token{tokenType: tokenType, value: lexeme, lexeme: lexeme, line: lineCount, offset: tokenStart})
+	}
+	// 文字列補間 "${...}" の中の式を読んでいる間、それぞれの式の中で開いている `{` の数
+	interpolations := make([]int, 0)
 	for i := 0; i < len(fileContents); i++ {
 		x := fileContents[i]
-		if x == '(' || x == ')' || x == '}' || x == '{' || x == '*' || x == '+' || x == '.' || x == ',' ||
-			x == '-' || x == ';' {
-			tokens = append(tokens, Token{tokenType: reservedTokens[string(x)], value: string(x)})
-		} else if x == '=' || x == '!' || x == '<' || x == '>' {
-			if i+1 < len(fileContents) && fileContents[i+1] == '=' {
-				tokens = append(tokens, Token{
-					tokenType: reservedTokens[string(x)+string(fileContents[i+1])],
-					value:     string(x) + string(fileContents[i+1]),
-				})
-				i++
-			} else {
-				tokens = append(tokens, Token{tokenType: reservedTokens[string(x)], value: string(x)})
+		tokenStart = i
+		if x == '"' || (x == '}' && len(interpolations) > 0 && interpolations[len(interpolations)-1] == 0) {
+			// 文字列の始まりか、補間の式が終わって文字列の続きを読む場合
+			if x == '}' {
+				interpolations = interpolations[:len(interpolations)-1]
 			}
-		} else if x == '"' {
-			string_token := make([]byte, 0)
+			start := i
+			var value strings.Builder
+			valid := true
+			interpolated := false
 
 			for i+1 < len(fileContents) && fileContents[i+1] != '"' {
 				i++
-				string_token = append(string_token, fileContents[i])
+				c := fileContents[i]
+				if c == '\n' {
+					lineCount++
+				}
+				if c == '$' && i+1 < len(fileContents) && fileContents[i+1] == '{' {
+					// ここまでを STRING_PART にして、補間の式を普通のトークンとして読む
+					i++
+					if valid {
+						tokens = append(tokens, Token{
+							tokenType: STRING_PART,
+							value:     value.String(),
+							lexeme:    string(fileContents[start : i+1]),
+							line:      lineCount,
+							offset:    start,
+						})
+					}
+					interpolations = append(interpolations, 0)
+					interpolated = true
+					break
+				}
+				if c == '\\' {
+					r, size, err := decodeEscape(fileContents[i:])
+					if err != nil {
+						addError(i, "%s", err)
+						valid = false
+					} else {
+						value.WriteRune(r)
+					}
+					// 不正なエスケープでも読み進めて、文字列の終わりを見失わないようにする。
+					// `\` の後ろの改行も読み飛ばすので、行番号を数え直す
+					lineCount += strings.Count(string(fileContents[i+1:i+size]), "\n")
+					i += size - 1
+					continue
+				}
+				if c >= utf8.RuneSelf {
+					r, size := utf8.DecodeRune(fileContents[i:])
+					if r == utf8.RuneError && size == 1 {
+						addError(i, "Invalid UTF-8 in string.")
+						valid = false
+					}
+					value.Write(fileContents[i : i+size])
+					i += size - 1
+					continue
+				}
+				value.WriteByte(c)
 			}
 
-			string_res := string(string_token)
-
+			if interpolated {
+				continue
+			}
 			if i+1 < len(fileContents) && fileContents[i+1] == '"' {
-				tokens = append(tokens,
-					Token{
-						tokenType: "STRING",
-						value:     string_res,
-					})
 				i++
-			} else if i+1 == len(fileContents) {
-				errCount++
-				fmt.Fprintf(os.Stderr, "[line %d] Error: Unterminated string.\n", lineCount)
+				if valid {
+					// 補間の後ろに続く最後の部分は STRING_END にする
+					tokenType := STRING
+					if x == '}' {
+						tokenType = STRING_END
+					}
+					tokens = append(tokens, Token{
+						tokenType: tokenType,
+						value:     value.String(),
+						lexeme:    string(fileContents[start : i+1]),
+						line:      lineCount,
+						offset:    start,
+					})
+				}
+			} else if i+1 >= len(fileContents) {
+				addError(start, "Unterminated string.")
 			}
-		} else if unicode.IsDigit(rune(x)) {
-			number_token := string(x)
-			number_formatted := ""
-			for i+1 < len(fileContents) && unicode.IsDigit(rune(fileContents[i+1])) {
-				i++
-				number_token += string(fileContents[i])
+		} else if strings.IndexByte("(){}+-*%.,;&|^~<>=!?:", x) >= 0 {
+			// 補間の式の中の { } の対応を数える
+			if len(interpolations) > 0 && x == '{' {
+				interpolations[len(interpolations)-1]++
+			} else if len(interpolations) > 0 && x == '}' {
+				interpolations[len(interpolations)-1]--
 			}
-			if i+1 < len(fileContents) && fileContents[i+1] == '.' {
+			// ==, <<, += のような二文字の演算子を優先する
+			if i+1 < len(fileContents) && reservedTokens[string(fileContents[i:i+2])] != "" {
+				addToken(reservedTokens[string(fileContents[i:i+2])], string(fileContents[i:i+2]))
 				i++
-				number_token += string(fileContents[i])
-				for i+1 < len(fileContents) && unicode.IsDigit(rune(fileContents[i+1])) {
-					i++
-					number_token += string(fileContents[i])
-				}
-				tmp_num, _ := strconv.ParseFloat(number_token, 64)
-				number_formatted = strconv.FormatFloat(tmp_num, 'g', -1, 64)
 			} else {
-				number_formatted = number_token
+				addToken(reservedTokens[string(x)], string(x))
+			}
+		} else if '0' <= x && x <= '9' {
+			end, value, ok := ScanNumber(fileContents, i)
+			if !ok {
+				addError(i, "Number literal out of range: %s", fileContents[i:end])
+				i = end - 1
+				continue
 			}
 			tokens = append(tokens, Token{
-				tokenType: "NUMBER",
-				value:     number_formatted,
+				tokenType: NUMBER,
+				value:     FormatNumber(value),
+				lexeme:    string(fileContents[i:end]),
+				line:      lineCount,
+				offset:    i,
 			})
-		} else if ('a' <= x && x <= 'z') || x == '_' || ('A' <= x && x <= 'Z') {
-			str := ""
-			str += string(x)
-			for i+1 < len(fileContents) && (('a' <= fileContents[i+1] && fileContents[i+1] <= 'z') ||
-				fileContents[i+1] == '_' || ('0' <= fileContents[i+1] && fileContents[i+1] <= '9') || ('A' <= fileContents[i+1] && fileContents[i+1] <= 'Z')) {
-				i++
-				str += string(fileContents[i])
+			i = end - 1
+		} else if r, size := utf8.DecodeRune(fileContents[i:]); isIdentifierStart(r) {
+			start := i
+			i += size - 1
+			for i+1 < len(fileContents) {
+				next, nextSize := utf8.DecodeRune(fileContents[i+1:])
+				if !isIdentifierStart(next) && !unicode.IsDigit(next) {
+					break
+				}
+				i += nextSize
 			}
+			str := string(fileContents[start : i+1])
 
 			if reservedWords[str] != "" {
-				tokens = append(tokens,
-					Token{
-						tokenType: reservedWords[str],
-						value:     str,
-					})
+				addToken(reservedWords[str], str)
 			} else {
-				tokens = append(tokens, Token{
-					tokenType: "IDENTIFIER",
-					value:     str,
-				})
+				addToken(IDENTIFIER, str)
 			}
 		} else if x == '/' {
 			if i+1 < len(fileContents) && fileContents[i+1] == '/' {
 				for i+1 < len(fileContents) && fileContents[i+1] != '\n' {
 					i++
 				}
+				comment := strings.TrimRight(string(fileContents[tokenStart:i+1]), " \t\r")
+				comments = append(comments, Token{tokenType: COMMENT, value: comment, lexeme: comment, line: lineCount, offset: tokenStart})
+			} else if i+1 < len(fileContents) && fileContents[i+1] == '=' {
+				addToken(SLASH_EQUAL, "/=")
+				i++
 			} else {
-				tokens = append(tokens, Token{
-					tokenType: "SLASH",
-					value:     string(x),
-				})
+				addToken(SLASH, string(x))
 			}
-		} else if x == ' ' || x == '\t' {
+		} else if x == ' ' || x == '\t' || x == '\r' {
 			// Ignore whitespace
 		} else if x == '\n' {
 			lineCount++
 		} else {
-			fmt.Fprintf(os.Stderr, "[line %d] Error: Unexpected character: %c\n", lineCount, x)
-			errCount++
+			// マルチバイト文字は一文字としてエラーにする
+			if r == utf8.RuneError && size == 1 {
+				addError(i, "Invalid UTF-8 byte: 0x%02x", x)
+			} else {
+				addError(i, "Unexpected character: %c", r)
+			}
+			i += size - 1
 		}
 	}
 
-	tokens = append(tokens, Token{tokenType: "EOF", value: ""})
+	if len(interpolations) > 0 {
+		addError(len(fileContents), "Unterminated string interpolation.")
+	}
 
-	// エラーが起こっていた場合は exit code 65 を返す
-	if errCount > 0 {
-		os.Exit(65)
+	tokens = append(tokens, Token{tokenType: EOF, value: "", lexeme: "", line: lineCount, offset: len(fileContents)})
+
+	return tokens, comments, errors
+}
+
+// isIdentifierStart は識別子の先頭に使える文字かどうかを返す。
+// ASCII に限らず Unicode の文字を識別子に使える
+func isIdentifierStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+// decodeEscape は `\` から始まるエスケープシーケンスを一つ読み、
+// 表す文字と読んだバイト数を返す
+func decodeEscape(source []byte) (rune, int, error) {
+	if len(source) < 2 {
+		return 0, 1, fmt.Errorf("Unterminated escape sequence.")
+	}
+	switch source[1] {
+	case 'n':
+		return '\n', 2, nil
+	case 't':
+		return '\t', 2, nil
+	case 'r':
+		return '\r', 2, nil
+	case '0':
+		return 0, 2, nil
+	case '"':
+		return '"', 2, nil
+	case '\\':
+		return '\\', 2, nil
+	case '$':
+		return '$', 2, nil
+	case 'u':
+		// \u{1F600} の形式
+		if len(source) < 3 || source[2] != '{' {
+			return 0, 2, fmt.Errorf("Invalid Unicode escape: expected '{' after \\u.")
+		}
+		end := 3
+		for end < len(source) && source[end] != '}' && source[end] != '"' && source[end] != '\n' && end-3 <= 6 {
+			end++
+		}
+		if end >= len(source) || source[end] != '}' {
+			return 0, end, fmt.Errorf("Invalid Unicode escape: missing '}'.")
+		}
+		code, err := strconv.ParseUint(string(source[3:end]), 16, 32)
+		if err != nil || end == 3 || end-3 > 6 {
+			return 0, end + 1, fmt.Errorf("Invalid Unicode escape: \\u{%s}.", source[3:end])
+		}
+		if !utf8.ValidRune(rune(code)) {
+			return 0, end + 1, fmt.Errorf("Invalid Unicode code point: U+%X.", code)
+		}
+		return rune(code), end + 1, nil
 	}
 
-	return tokens
+	r, size := utf8.DecodeRune(source[1:])
+	// 改行などでもエラーが一行に収まるように、文字は引用符で囲んでエスケープする
+	return 0, 1 + size, fmt.Errorf("Invalid escape sequence: '\\' followed by %q.", r)
 }
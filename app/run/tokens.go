@@ -42,6 +42,37 @@ const (
 	CLASS         = "CLASS"
 	SUPER         = "SUPER"
 	THIS          = "THIS"
+	PERCENT       = "PERCENT"
+	STAR_STAR     = "STAR_STAR"
+	TILDE_SLASH   = "TILDE_SLASH"
+
+	// ビット演算子
+	AMPERSAND       = "AMPERSAND"
+	PIPE            = "PIPE"
+	CARET           = "CARET"
+	TILDE           = "TILDE"
+	LESS_LESS       = "LESS_LESS"
+	GREATER_GREATER = "GREATER_GREATER"
+
+	// 複合代入とインクリメント・デクリメント
+	PLUS_EQUAL    = "PLUS_EQUAL"
+	MINUS_EQUAL   = "MINUS_EQUAL"
+	STAR_EQUAL    = "STAR_EQUAL"
+	SLASH_EQUAL   = "SLASH_EQUAL"
+	PERCENT_EQUAL = "PERCENT_EQUAL"
+	PLUS_PLUS     = "PLUS_PLUS"
+	MINUS_MINUS   = "MINUS_MINUS"
+
+	// 文字列補間で "${" の手前までの部分と、最後の "}" より後ろの部分
+	STRING_PART = "STRING_PART"
+	STRING_END  = "STRING_END"
+
+	// 条件式
+	QUESTION = "QUESTION"
+	COLON    = "COLON"
+
+	// `//` から行末までのコメント。パーサーには渡さない
+	COMMENT = "COMMENT"
 )
 
 var reservedTokens = map[string]string{
@@ -64,6 +95,24 @@ var reservedTokens = map[string]string{
 	">=":     GREATER_EQUAL,
 	"/":      SLASH,
 	"!":      BANG,
+	"%":      PERCENT,
+	"**":     STAR_STAR,
+	"~/":     TILDE_SLASH,
+	"&":      AMPERSAND,
+	"|":      PIPE,
+	"^":      CARET,
+	"~":      TILDE,
+	"<<":     LESS_LESS,
+	">>":     GREATER_GREATER,
+	"+=":     PLUS_EQUAL,
+	"-=":     MINUS_EQUAL,
+	"*=":     STAR_EQUAL,
+	"/=":     SLASH_EQUAL,
+	"%=":     PERCENT_EQUAL,
+	"++":     PLUS_PLUS,
+	"--":     MINUS_MINUS,
+	"?":      QUESTION,
+	":":      COLON,
 	"print":  PRINT,
 	"var":    VAR,
 	"if":     IF,
@@ -76,6 +125,18 @@ var reservedTokens = map[string]string{
 	"return": RETURN,
 }
 
+// updateOperators は複合代入とインクリメント・デクリメントを、
+// 変数の今の値に適用する二項演算子に対応させる
+var updateOperators = map[string]Token{
+	PLUS_EQUAL:    {tokenType: PLUS, value: "+", lexeme: "+"},
+	MINUS_EQUAL:   {tokenType: MINUS, value: "-", lexeme: "-"},
+	STAR_EQUAL:    {tokenType: STAR, value: "*", lexeme: "*"},
+	SLASH_EQUAL:   {tokenType: SLASH, value: "/", lexeme: "/"},
+	PERCENT_EQUAL: {tokenType: PERCENT, value: "%", lexeme: "%"},
+	PLUS_PLUS:     {tokenType: PLUS, value: "+", lexeme: "+"},
+	MINUS_MINUS:   {tokenType: MINUS, value: "-", lexeme: "-"},
+}
+
 var reservedWords = map[string]string{
 	"and":    AND,
 	"class":  CLASS,
@@ -93,4 +154,4 @@ var reservedWords = map[string]string{
 	"true":   TRUE,
 	"var":    VAR,
 	"while":  WHILE,
-}
\ No newline at end of file
+}
@@ -0,0 +1,87 @@
+package run
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// tracer は実行した文、変数への代入、関数の呼び出しと戻り値を書き出す。
+// 関数の中の出力は呼び出しの深さに応じてインデントする
+type tracer struct {
+	w       io.Writer
+	program *Program
+	// 空でなければ、この名前の関数の呼び出しの中だけを書き出す
+	function string
+	// function の関数の呼び出しが何重に実行中か
+	active int
+	depth  int
+}
+
+// EnableTracing は実行の様子を w に書き出すようにする。
+// function を指定すると、その名前の関数の呼び出しとその中で起きたことだけを書き出す
+func (in *Interpreter) EnableTracing(w io.Writer, program *Program, function string) {
+	in.tracer = &tracer{w: w, program: program, function: function}
+}
+
+func (t *tracer) enabled() bool {
+	return t.function == "" || t.active > 0
+}
+
+func (t *tracer) printf(format string, args ...any) {
+	fmt.Fprintf(t.w, strings.Repeat("  ", t.depth)+format+"\n", args...)
+}
+
+// statement は文を実行する前に、行番号と文のソースを書き出す
+func (t *tracer) statement(statement Statement) {
+	if t.enabled() {
+		t.printf("[line %d] %s", statement.getLine(), t.program.text(statement))
+	}
+}
+
+// assign は変数に値が入ったことを書き出す
+func (t *tracer) assign(name string, value EvaluateNode) {
+	if t.enabled() {
+		t.printf("%s = %s", name, traceValue(value))
+	}
+}
+
+// enter は関数の呼び出しを引数と一緒に書き出す
+func (t *tracer) enter(function *Function, args []EvaluateNode) {
+	if function.name == t.function {
+		t.active++
+	}
+	if !t.enabled() {
+		return
+	}
+	parts := make([]string, 0, len(args))
+	for i, arg := range args {
+		if i < len(function.parameters) {
+			parts = append(parts, function.parameters[i]+" = "+traceValue(arg))
+		} else {
+			parts = append(parts, traceValue(arg))
+		}
+	}
+	t.printf("-> %s(%s)", function.name, strings.Join(parts, ", "))
+	t.depth++
+}
+
+// exit は関数から戻ったことを戻り値と一緒に書き出す
+func (t *tracer) exit(function *Function, result EvaluateNode) {
+	if t.enabled() {
+		t.depth--
+		t.printf("<- %s = %s", function.name, traceValue(result))
+	}
+	if function.name == t.function {
+		t.active--
+	}
+}
+
+// traceValue は値を書き出す形にする。文字列は他の値と区別できるように引用符で囲む
+func traceValue(value EvaluateNode) string {
+	if value.valueType == STRING {
+		return strconv.Quote(value.value)
+	}
+	return stringify(value)
+}
@@ -0,0 +1,104 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codecrafters-io/interpreter-starter-go/app/run"
+)
+
+// Test はスクリプトを一つずつ実行し、結果とカバレッジを表示する。
+// ディレクトリを渡すとその中の *.lox を全て実行する。
+// 同じ名前の .out ファイルがあれば print の出力がそれと一致しないものを、
+// 無ければ実行時エラーや 0 以外の exit で終わったものを失敗とし、終了コード 1 を返す
+func Test() {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	cover := flags.String("cover", "", "write LCOV line and branch coverage of all scripts to `file`")
+	flags.Parse(os.Args[2:])
+	if flags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: ./your_program.sh test [--cover=file] <file or directory>...")
+		os.Exit(1)
+	}
+
+	var filenames []string
+	for _, path := range flags.Args() {
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+			os.Exit(1)
+		}
+		if !info.IsDir() {
+			filenames = append(filenames, path)
+			continue
+		}
+		matches, _ := filepath.Glob(filepath.Join(path, "*.lox"))
+		filenames = append(filenames, matches...)
+	}
+
+	coverage := run.NewCoverage()
+	failed := 0
+	for _, filename := range filenames {
+		if message := testFile(filename, coverage); message != "" {
+			failed++
+			fmt.Printf("FAIL %s\n    %s\n", filename, strings.ReplaceAll(message, "\n", "\n    "))
+			continue
+		}
+		fmt.Printf("ok   %s\n", filename)
+	}
+	fmt.Printf("%d passed, %d failed\n", len(filenames)-failed, failed)
+	coverage.WriteSummary(os.Stdout)
+
+	if *cover != "" {
+		file, err := os.Create(*cover)
+		if err == nil {
+			err = coverage.WriteLCOV(file)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing coverage: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// testFile は filename を実行し、失敗した理由を返す。成功した場合は空文字列
+func testFile(filename string, coverage *run.Coverage) string {
+	fileContents, err := os.ReadFile(filename)
+	if err != nil {
+		return err.Error()
+	}
+	program, ok := run.ParseProgram(fileContents)
+	if !ok {
+		return "syntax error"
+	}
+
+	var out bytes.Buffer
+	interpreter := run.NewInterpreter(run.DefaultPolicy())
+	interpreter.SetOutput(&out)
+	interpreter.EnableCoverage(coverage, filename, program.Statements())
+	runErr := interpreter.Interpret(context.Background(), program.Statements())
+
+	expected, err := os.ReadFile(strings.TrimSuffix(filename, ".lox") + ".out")
+	if err != nil {
+		// 期待する出力が無ければ、エラー無く終われば成功とする
+		if exitErr, ok := runErr.(*run.ExitError); runErr != nil && (!ok || exitErr.Code != 0) {
+			return runErr.Error()
+		}
+		return ""
+	}
+	// 期待する出力があれば、実行時エラーで終わることも含めて出力だけを比べる
+	if !bytes.Equal(expected, out.Bytes()) {
+		return fmt.Sprintf("output differs from %s.out", strings.TrimSuffix(filename, ".lox"))
+	}
+	return ""
+}
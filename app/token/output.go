@@ -0,0 +1,147 @@
+package token
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/codecrafters-io/interpreter-starter-go/app/run"
+)
+
+// output はトークンを `TYPE lexeme literal` の形式で一行ずつ出力する
+type output struct {
+	writer *bufio.Writer
+}
+
+func (o *output) stdout() *bufio.Writer {
+	if o.writer == nil {
+		o.writer = bufio.NewWriter(os.Stdout)
+	}
+	return o.writer
+}
+
+func (o *output) token(tokenType string, lexeme string, literal string) {
+	fmt.Fprintf(o.stdout(), "%s %s %s\n", tokenType, lexeme, literal)
+}
+
+// error は line の行のエラーとして標準エラーに出力する
+func (o *output) error(line int, message string) {
+	// 標準出力と順番が入れ替わらないように、先に出力済みのトークンを書き出す
+	o.stdout().Flush()
+	fmt.Fprintf(os.Stderr, "[line %d] Error: %s\n", line, message)
+}
+
+func (o *output) flush() {
+	o.stdout().Flush()
+}
+
+// tokenRecord は json と tsv で出力するトークン一つ分の情報。
+// line と column は 1 から数え、column は文字単位、offset はバイト位置
+type tokenRecord struct {
+	Type    string `json:"type"`
+	Lexeme  string `json:"lexeme"`
+	Literal any    `json:"literal"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Offset  int    `json:"offset"`
+}
+
+// errorRecord は字句解析のエラー一つ分の情報
+type errorRecord struct {
+	Message string `json:"message"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Offset  int    `json:"offset"`
+}
+
+// writeStructured は run.Tokenize の結果を json か tsv で標準出力に書き出す。
+// エラーもレコードとして出力し、エラーが無ければ true を返す
+func writeStructured(format string, source []byte) bool {
+	tokens, errors := run.Tokenize(source)
+
+	lineStarts := []int{0}
+	for i, c := range source {
+		if c == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	// position はバイト位置を 1 から数える行と列にする。列は文字単位
+	position := func(offset int) (int, int) {
+		line := sort.Search(len(lineStarts), func(i int) bool { return lineStarts[i] > offset }) - 1
+		return line + 1, utf8.RuneCount(source[lineStarts[line]:offset]) + 1
+	}
+
+	tokenRecords := make([]tokenRecord, 0, len(tokens))
+	for _, token := range tokens {
+		line, column := position(token.Offset)
+		tokenRecords = append(tokenRecords, tokenRecord{
+			Type:    token.Type,
+			Lexeme:  token.Lexeme,
+			Literal: literalValue(token),
+			Line:    line,
+			Column:  column,
+			Offset:  token.Offset,
+		})
+	}
+	errorRecords := make([]errorRecord, 0, len(errors))
+	for _, err := range errors {
+		line, column := position(err.Offset)
+		errorRecords = append(errorRecords, errorRecord{
+			Message: err.Message,
+			Line:    line,
+			Column:  column,
+			Offset:  err.Offset,
+		})
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(struct {
+			Tokens []tokenRecord `json:"tokens"`
+			Errors []errorRecord `json:"errors"`
+		}{tokenRecords, errorRecords})
+		return len(errors) == 0
+	}
+
+	fmt.Fprintln(w, "type\tlexeme\tliteral\tline\tcolumn\toffset")
+	for _, token := range tokenRecords {
+		literal := ""
+		if token.Literal != nil {
+			literal = fmt.Sprint(token.Literal)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\n",
+			token.Type, escapeTSV(token.Lexeme), escapeTSV(literal), token.Line, token.Column, token.Offset)
+	}
+	for _, err := range errorRecords {
+		fmt.Fprintf(w, "ERROR\t\t%s\t%d\t%d\t%d\n", escapeTSV(err.Message), err.Line, err.Column, err.Offset)
+	}
+	return len(errors) == 0
+}
+
+// literalValue はトークンのリテラルを JSON の値にする。
+// 文字列は文字列、数値は JSON の数値にし、リテラルの無いトークンは null にする
+func literalValue(token run.LexToken) any {
+	switch token.Type {
+	case run.STRING, run.STRING_PART, run.STRING_END:
+		return token.Literal
+	case run.NUMBER:
+		if value, err := strconv.ParseFloat(token.Literal, 64); err == nil {
+			return value
+		}
+		return token.Literal
+	}
+	return nil
+}
+
+// escapeTSV はタブと改行を含む値を一行に収まるようにエスケープする
+func escapeTSV(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`).Replace(value)
+}